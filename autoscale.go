@@ -0,0 +1,173 @@
+package fbp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// AutoscaleSpec sets the bounds of the concurrency of a component, and
+	// when it's changed
+	AutoscaleSpec struct {
+		Min int
+		Max int
+		// Interval is how often the component is checked
+		Interval time.Duration
+		// ScaleUpDepth scales up when there are more packages than it per
+		// worker waiting in the in channel. The packages waiting are the
+		// ones in the channel buffer, so it must be buffered
+		ScaleUpDepth int
+		// ScaleDownDepth scales down when there are as many packages as it
+		// per worker waiting, or less, and the latency is under MaxLatency
+		// or there aren't packages waiting
+		ScaleDownDepth int
+		// MaxLatency scales up when the average task latency exceeds it and
+		// there are packages waiting. The latency is ignored while there
+		// aren't any, as it's not measured while the task is idle. Zero
+		// ignores it always
+		MaxLatency time.Duration
+		// Step is how many workers are added or removed each time. By
+		// default it's one
+		Step int
+		// UpCooldown and DownCooldown are how long after scaling it can
+		// scale up and down again
+		UpCooldown   time.Duration
+		DownCooldown time.Duration
+	}
+
+	// Autoscaler changes the concurrency of a component between the spec
+	// bounds, watching how many packages are waiting in its in channel and
+	// how long its task takes. It publishes a ComponentScaled event each time
+	Autoscaler struct {
+		ctx       context.Context
+		id        string
+		component *Component
+		spec      AutoscaleSpec
+		clock     Clock
+		events    *EventBus
+		logger    Logger
+
+		mux    sync.Mutex
+		last   time.Time
+		scaled bool
+	}
+)
+
+func NewAutoscaler(ctx context.Context, id string, component *Component, spec AutoscaleSpec, logger Logger) (*Autoscaler, error) {
+	if spec.Min < 1 || spec.Max < spec.Min {
+		return nil, errors.New("to autoscale a component, the min workers must be at least one, and the max ones at least the min")
+	}
+	if spec.Interval <= 0 {
+		return nil, errors.New("to autoscale a component, the interval must be positive")
+	}
+	if cap(component.port.In) == 0 {
+		return nil, errors.New("to autoscale a component, its in channel must be buffered, as the packages waiting are counted in it")
+	}
+	if spec.Step < 1 {
+		spec.Step = 1
+	}
+	return &Autoscaler{
+		ctx:       ctx,
+		id:        id,
+		component: component,
+		spec:      spec,
+		clock:     component.clock,
+		events:    component.events,
+		logger:    logger.With(String("component_id", component.id)),
+	}, nil
+}
+
+// SetClock sets the clock the checks and the cooldowns are timed with. By
+// default it's the clock of the component. It must be called before streaming
+func (a *Autoscaler) SetClock(clock Clock) {
+	a.clock = clock
+}
+
+// SetEventBus makes the autoscaler publish its decisions to the bus. By
+// default it's the bus of the component. It must be called before streaming
+func (a *Autoscaler) SetEventBus(bus *EventBus) {
+	a.events = bus
+}
+
+func (a *Autoscaler) Stream() {
+	go func() {
+		a.logger.Info("autoscaler starting", Int("min", a.spec.Min), Int("max", a.spec.Max))
+		ticker := a.clock.NewTicker(a.spec.Interval)
+		defer ticker.Stop()
+
+		a.Check()
+		for {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-ticker.C():
+				a.Check()
+			}
+		}
+	}()
+
+	return
+}
+
+// Check scales the component if it's needed. Stream calls it every interval
+func (a *Autoscaler) Check() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	now := a.clock.Now()
+	workers := a.component.Concurrency()
+	depth := len(a.component.port.In)
+	latency := a.component.Latency()
+
+	target, reason := a.decide(now, workers, depth, latency)
+	if target == workers {
+		return
+	}
+	a.component.SetConcurrency(target)
+	a.last, a.scaled = now, true
+
+	a.logger.Info("component scaled", Int("from", workers), Int("to", target), String("reason", reason), Int("depth", depth), Duration("latency", latency))
+	a.events.Publish(Event{
+		Kind:   ComponentScaled,
+		Time:   now,
+		Source: a.component.id,
+		Port:   a.component.port.ID,
+		Detail: fmt.Sprintf("%d -> %d workers: %s", workers, target, reason),
+	})
+}
+
+// decide returns the workers the component must have, and why
+func (a *Autoscaler) decide(now time.Time, workers int, depth int, latency time.Duration) (target int, reason string) {
+	perWorker := float64(depth) / float64(workers)
+	slow := a.spec.MaxLatency > 0 && latency > a.spec.MaxLatency && depth > 0
+	cooled := func(cooldown time.Duration) bool {
+		return !a.scaled || now.Sub(a.last) >= cooldown
+	}
+
+	switch {
+	case workers < a.spec.Min:
+		return a.spec.Min, "under the min"
+	case workers > a.spec.Max:
+		return a.spec.Max, "over the max"
+	case workers < a.spec.Max && cooled(a.spec.UpCooldown) && perWorker > float64(a.spec.ScaleUpDepth):
+		return bound(workers+a.spec.Step, a.spec.Min, a.spec.Max), fmt.Sprintf("%d packages waiting", depth)
+	case workers < a.spec.Max && cooled(a.spec.UpCooldown) && slow:
+		return bound(workers+a.spec.Step, a.spec.Min, a.spec.Max), fmt.Sprintf("latency %s", latency)
+	case workers > a.spec.Min && cooled(a.spec.DownCooldown) && perWorker <= float64(a.spec.ScaleDownDepth) && !slow:
+		return bound(workers-a.spec.Step, a.spec.Min, a.spec.Max), fmt.Sprintf("%d packages waiting", depth)
+	}
+	return workers, ""
+}
+
+func bound(n int, min int, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package fbp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+// waitDepth waits until there are n packages waiting in a channel
+func waitDepth(t *testing.T, ch chan *fbp.InformationPackage, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); len(ch) != n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d packages waiting, want %d", len(ch), n)
+		}
+	}
+}
+
+func TestAutoscaler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The task holds the packages until the gate is opened
+	gate := make(chan struct{})
+	task := fbp.ContextTaskFunc(func(ctx context.Context, in *fbp.InformationPackage) (*fbp.InformationPackage, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-gate:
+			return in, nil
+		}
+	})
+	clock := fbp.NewFakeClock(time.Now())
+	bus := fbp.NewEventBus()
+	events, unsubscribe := bus.Subscribe(10)
+	defer unsubscribe()
+	component := fbp.NewComponentWith(ctx, "gated", task, fbp.WithClock(clock), fbp.WithEventBus(bus), fbp.WithBuffer(10))
+	autoscaler, err := fbp.NewAutoscaler(ctx, "autoscaler", component, fbp.AutoscaleSpec{
+		Min:          1,
+		Max:          3,
+		Interval:     time.Second,
+		ScaleUpDepth: 1,
+		MaxLatency:   500 * time.Millisecond,
+		UpCooldown:   5 * time.Second,
+		DownCooldown: 5 * time.Second,
+	}, fbp.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	component.Stream()
+
+	// Each worker holds a package, and the component one more waiting for
+	// a worker
+	const packages = 6
+	for k := 0; k < packages; k++ {
+		component.Port().In <- numberPackage(k)
+	}
+	steps := []struct {
+		name    string
+		advance time.Duration
+		depth   int
+		workers int
+	}{
+		{name: "scale up", depth: packages - 2, workers: 2},
+		{name: "up cooldown", depth: packages - 3, workers: 2},
+		{name: "cooled down", advance: 5 * time.Second, depth: packages - 3, workers: 3},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		waitDepth(t, component.Port().In, step.depth)
+		autoscaler.Check()
+		if got := component.Concurrency(); got != step.workers {
+			t.Fatalf("%s: got %d workers, want %d", step.name, got, step.workers)
+		}
+	}
+
+	// The packages took longer than the max latency, which doesn't keep the
+	// idle component from scaling down
+	close(gate)
+	receiveIDs(t, component.Port().Out, packages)
+	autoscaler.Check()
+	if got := component.Concurrency(); got != 3 {
+		t.Fatalf("down cooldown: got %d workers, want 3", got)
+	}
+	clock.Advance(5 * time.Second)
+	autoscaler.Check()
+	if got := component.Concurrency(); got != 2 {
+		t.Fatalf("idle: got %d workers, want 2", got)
+	}
+	if latency := component.Latency(); latency <= 500*time.Millisecond {
+		t.Errorf("got latency %s, want it over the max one", latency)
+	}
+
+	// The events go to the bus of the component
+	var scaled int
+	for len(events) > 0 {
+		if event := <-events; event.Kind == fbp.ComponentScaled {
+			scaled++
+		}
+	}
+	if scaled != 3 {
+		t.Errorf("got %d scaled events, want 3", scaled)
+	}
+}
@@ -0,0 +1,177 @@
+package fbp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type (
+	// BatchSpec sets when a batch is emitted: when it reaches Size packages,
+	// when the next package would exceed Bytes as measured by Sizer, or when
+	// its first package has been waiting MaxLatency. Zero values disable
+	// each limit, but at least one of them is required
+	BatchSpec struct {
+		Size       int
+		Bytes      int
+		Sizer      func(ip *InformationPackage) int
+		MaxLatency time.Duration
+	}
+
+	// Batch is the status item of the packages emitted by a batcher
+	Batch struct {
+		Packages  []*InformationPackage
+		SourceIDs []string
+	}
+
+	// Batcher is the task of a component that accumulates the packages it
+	// receives, and sends them as a single batch package
+	Batcher struct {
+		streamer
+		spec BatchSpec
+
+		c       *Component
+		batches int
+		current []*InformationPackage
+		bytes   int
+	}
+
+	// Splitter is the task of a component that sends each of the packages of
+	// the batches it receives. Other packages go through untouched
+	Splitter struct {
+		streamer
+	}
+)
+
+func (b Batch) Key() func() string {
+	return func() string {
+		return "batch"
+	}
+}
+
+func NewBatcher(spec BatchSpec) (b *Batcher, err error) {
+	if spec.Size <= 0 && spec.Bytes <= 0 && spec.MaxLatency <= 0 {
+		return nil, errors.New("batcher requires a size, a bytes or a latency limit")
+	}
+	if spec.Bytes > 0 && spec.Sizer == nil {
+		return nil, errors.New("batcher bytes limit requires a sizer")
+	}
+	return &Batcher{spec: spec}, nil
+}
+
+// stream measures the max latency with the component clock
+func (b *Batcher) stream(c *Component) {
+	b.c = c
+	c.logger.Info("batcher starting")
+
+	timer := c.clock.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-timer.C():
+			if !b.flush() {
+				return
+			}
+		case informationPackage, ok := <-c.port.In:
+			if !ok {
+				c.logger.Warn("in port closed")
+				b.flush()
+				return
+			}
+			if informationPackage.IsBarrier() {
+				// Batches don't cross checkpoints
+				if !b.flush() || !c.send(informationPackage) {
+					return
+				}
+				continue
+			}
+
+			size := 0
+			if b.spec.Bytes > 0 {
+				size = b.spec.Sizer(informationPackage)
+				if len(b.current) > 0 && b.bytes+size > b.spec.Bytes {
+					if !b.flush() {
+						return
+					}
+				}
+			}
+			if len(b.current) == 0 && b.spec.MaxLatency > 0 {
+				stopTimer(timer)
+				timer.Reset(b.spec.MaxLatency)
+			}
+			b.current = append(b.current, informationPackage)
+			b.bytes += size
+
+			full := b.spec.Size > 0 && len(b.current) >= b.spec.Size
+			full = full || (b.spec.Bytes > 0 && b.bytes >= b.spec.Bytes)
+			if full {
+				stopTimer(timer)
+				if !b.flush() {
+					return
+				}
+			}
+		}
+	}
+}
+
+func stopTimer(timer Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C():
+		default:
+		}
+	}
+}
+
+func (b *Batcher) flush() bool {
+	if len(b.current) == 0 {
+		return true
+	}
+	batch := Batch{
+		Packages:  b.current,
+		SourceIDs: make([]string, len(b.current)),
+	}
+	for k, informationPackage := range b.current {
+		batch.SourceIDs[k] = informationPackage.ID
+	}
+	b.batches++
+	b.current, b.bytes = nil, 0
+	return b.c.send(NewInformationPackage(fmt.Sprintf("%s_batch_%d", b.c.id, b.batches), batch))
+}
+
+func NewSplitter() *Splitter {
+	return &Splitter{}
+}
+
+func (s *Splitter) stream(c *Component) {
+	c.logger.Info("splitter starting")
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case informationPackage, ok := <-c.port.In:
+			if !ok {
+				c.logger.Warn("in port closed")
+				return
+			}
+			for _, out := range s.split(informationPackage) {
+				if !c.send(out) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Splitter) split(informationPackage *InformationPackage) []*InformationPackage {
+	for _, item := range statusItems(informationPackage.Status) {
+		if batch, ok := item.(Batch); ok {
+			return batch.Packages
+		}
+	}
+	return []*InformationPackage{informationPackage}
+}
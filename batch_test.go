@@ -0,0 +1,141 @@
+package fbp_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+// describeBatch returns the source IDs of a batch package, or the ID of any other
+func describeBatch(ip *fbp.InformationPackage) string {
+	item, err := ip.Status.Peek(fbp.Batch{}.Key())
+	if err != nil {
+		return ip.ID
+	}
+	return "[" + strings.Join(item.(fbp.Batch).SourceIDs, " ") + "]"
+}
+
+func TestBatcher(t *testing.T) {
+	sizer := func(ip *fbp.InformationPackage) int { return numberOf(ip) }
+	tests := []struct {
+		name string
+		spec fbp.BatchSpec
+		in   []*fbp.InformationPackage
+		want []string
+	}{
+		{
+			name: "size",
+			spec: fbp.BatchSpec{Size: 2},
+			in:   []*fbp.InformationPackage{numberPackage(1), numberPackage(2), numberPackage(3), numberPackage(4), numberPackage(5)},
+			want: []string{"[n1 n2]", "[n3 n4]", "[n5]"},
+		},
+		{
+			name: "bytes",
+			spec: fbp.BatchSpec{Bytes: 5, Sizer: sizer},
+			in:   []*fbp.InformationPackage{numberPackage(1), numberPackage(2), numberPackage(3), numberPackage(4), numberPackage(5)},
+			want: []string{"[n1 n2]", "[n3]", "[n4]", "[n5]"},
+		},
+		{
+			name: "barrier",
+			spec: fbp.BatchSpec{Size: 10},
+			in:   []*fbp.InformationPackage{numberPackage(1), numberPackage(2), fbp.NewBarrier(1), numberPackage(3)},
+			want: []string{"[n1 n2]", "barrier_1", "[n3]"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			batcher, err := fbp.NewBatcher(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			component := fbp.NewComponentWith(ctx, "batcher", batcher)
+			component.Stream()
+
+			// Closing the in port sends the last batch
+			for _, ip := range tc.in {
+				component.Port().In <- ip
+			}
+			close(component.Port().In)
+			if err = component.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for len(component.Port().Out) > 0 {
+				got = append(got, describeBatch(<-component.Port().Out))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBatcherMaxLatency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := fbp.NewFakeClock(time.Now())
+	batcher, err := fbp.NewBatcher(fbp.BatchSpec{Size: 10, MaxLatency: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	component := fbp.NewComponentWith(ctx, "batcher", batcher, fbp.WithClock(clock), fbp.WithBuffer(0))
+	component.Stream()
+
+	// The latency is measured from the first package of the batch
+	component.Port().In <- numberPackage(1)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second / 2)
+	component.Port().In <- numberPackage(2)
+	select {
+	case ip := <-component.Port().Out:
+		t.Fatalf("batch %s sent before its max latency", describeBatch(ip))
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second / 2)
+	select {
+	case ip := <-component.Port().Out:
+		if got := describeBatch(ip); got != "[n1 n2]" {
+			t.Errorf("got %s, want [n1 n2]", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch not sent after its max latency")
+	}
+}
+
+func TestSplitter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	component := fbp.NewComponentWith(ctx, "splitter", fbp.NewSplitter())
+	component.Stream()
+
+	batch := fbp.Batch{
+		Packages:  []*fbp.InformationPackage{numberPackage(1), numberPackage(2)},
+		SourceIDs: []string{"n1", "n2"},
+	}
+	component.Port().In <- fbp.NewInformationPackage("batch", batch)
+	component.Port().In <- numberPackage(3)
+
+	want := []string{"n1", "n2", "n3"}
+	if got := receiveIDs(t, component.Port().Out, len(want)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewBatcherValidation(t *testing.T) {
+	for _, spec := range []fbp.BatchSpec{{}, {Bytes: 10}} {
+		if _, err := fbp.NewBatcher(spec); err == nil {
+			t.Errorf("spec %+v accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package fbp
+
+import (
+	"errors"
+)
+
+const (
+	// BroadcastBlockAll delivers each package to all the ports before reading
+	// the next one, so the slowest port sets the pace of all of them
+	BroadcastBlockAll BroadcastPolicy = iota
+	// BroadcastBuffered gives each port its own buffer, so a slow port only
+	// holds the others back once its buffer is full
+	BroadcastBuffered
+)
+
+type (
+	BroadcastPolicy int
+
+	// Cloner returns a copy of the package for a broadcast branch
+	Cloner func(informationPackage *InformationPackage) *InformationPackage
+)
+
+// StreamBroadcast delivers every package to all the to ports. Without a
+// cloner all the ports share the same package, which must then be handled
+// as read only. With a cloner, each port but the first one gets its own copy
+func (c *Connection) StreamBroadcast(from *Port, to []Port, cloner Cloner, policy BroadcastPolicy, bufferSz int) (err error) {
+	if len(to) == 0 {
+		return errors.New("to stream a broadcast connection, at least one out port is required")
+	}
+
+	copies := func(informationPackage *InformationPackage) []*InformationPackage {
+		packages := make([]*InformationPackage, len(to))
+		for k := range to {
+			packages[k] = informationPackage
+			if k > 0 && cloner != nil && !informationPackage.IsBarrier() {
+				packages[k] = cloner(informationPackage)
+			}
+		}
+		return packages
+	}
+
+	switch policy {
+	case BroadcastBlockAll:
+		go func() {
+			c.logger.Info("starting broadcast connection", Int("out", len(to)))
+			c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
+			for {
+				select {
+				case <-c.ctx.Done():
+					return
+				case informationPackage, ok := <-from.Out:
+					if !ok {
+						return
+					}
+					for k, branchPackage := range copies(informationPackage) {
+						if !c.send(from, &to[k], branchPackage) {
+							return
+						}
+					}
+				}
+			}
+		}()
+	case BroadcastBuffered:
+		branches := make([]chan *InformationPackage, len(to))
+		for k := range to {
+			branches[k] = make(chan *InformationPackage, bufferSz)
+			go func(k int) {
+				for {
+					select {
+					case <-c.ctx.Done():
+						return
+					case informationPackage, ok := <-branches[k]:
+						if !ok {
+							return
+						}
+						if !c.send(from, &to[k], informationPackage) {
+							return
+						}
+					}
+				}
+			}(k)
+		}
+		go func() {
+			c.logger.Info("starting buffered broadcast connection", Int("out", len(to)), Int("buffer", bufferSz))
+			c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
+			// The branches deliver what they have buffered, and then return
+			defer func() {
+				for k := range branches {
+					close(branches[k])
+				}
+			}()
+			for {
+				select {
+				case <-c.ctx.Done():
+					return
+				case informationPackage, ok := <-from.Out:
+					if !ok {
+						return
+					}
+					for k, branchPackage := range copies(informationPackage) {
+						select {
+						case <-c.ctx.Done():
+							return
+						case branches[k] <- branchPackage:
+						}
+					}
+				}
+			}
+		}()
+	default:
+		return errors.New("unknown broadcast policy")
+	}
+	return
+}
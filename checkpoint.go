@@ -0,0 +1,335 @@
+package fbp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	Checkpoints follow the Chandy-Lamport snapshot algorithm with aligned
+	barriers. A barrier package is injected at the source ports, and it flows
+	through the graph behind the packages sent before it. When a component
+	receives a barrier, it has processed all the packages of the checkpoint,
+	so it snapshots its state and forwards the barrier. When a port is fed
+	by several inputs, the inputs that have already delivered the barrier are
+	held until the barrier arrives from all of them.
+*/
+
+const (
+	checkpointDirPrefix = "checkpoint-"
+	checkpointComplete  = "_COMPLETE"
+	checkpointStateExt  = ".state"
+)
+
+var ErrNoCheckpoint = errors.New("there is not any complete checkpoint")
+
+type (
+	// Stateful is implemented by the tasks which state must survive a restart
+	Stateful interface {
+		Snapshot() (state []byte, err error)
+		Restore(state []byte) (err error)
+	}
+
+	Checkpointer struct {
+		dir    string
+		logger Logger
+
+		mux        sync.Mutex
+		last       int64
+		components map[string]Stateful
+		pending    map[int64]map[string]bool
+		done       map[int64]chan struct{}
+	}
+
+	barrierAligner struct {
+		inputs  int
+		mux     sync.Mutex
+		arrived map[int64]int
+		aligned map[int64]chan struct{}
+	}
+)
+
+func NewCheckpointer(dir string, logger Logger) (cp *Checkpointer, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	cp = &Checkpointer{
+		dir:        dir,
+		logger:     logger,
+		components: make(map[string]Stateful),
+		pending:    make(map[int64]map[string]bool),
+		done:       make(map[int64]chan struct{}),
+	}
+	ids, err := cp.checkpoints(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		cp.last = ids[len(ids)-1]
+	}
+	return
+}
+
+// Register adds a component to the ones that must save its state for a
+// checkpoint to be complete
+func (cp *Checkpointer) Register(componentID string, state Stateful) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	cp.components[componentID] = state
+}
+
+func (cp *Checkpointer) Unregister(componentID string) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	delete(cp.components, componentID)
+	for id := range cp.pending {
+		cp.saved(id, componentID)
+	}
+}
+
+// Trigger starts a new checkpoint sending its barrier to the source ports,
+// and waits until all the registered components have saved their state
+func (cp *Checkpointer) Trigger(ctx context.Context, sources ...*Port) (id int64, err error) {
+	cp.mux.Lock()
+	cp.last++
+	id = cp.last
+	pending := make(map[string]bool, len(cp.components))
+	for componentID := range cp.components {
+		pending[componentID] = true
+	}
+	done := make(chan struct{})
+	cp.pending[id] = pending
+	cp.done[id] = done
+	if err = os.MkdirAll(cp.checkpointDir(id), 0755); err != nil {
+		delete(cp.pending, id)
+		delete(cp.done, id)
+		cp.mux.Unlock()
+		return
+	}
+	if len(pending) == 0 {
+		err = cp.complete(id)
+	}
+	cp.mux.Unlock()
+	if err != nil {
+		return
+	}
+
+	cp.logger.Info("checkpoint triggered", Int64("checkpoint_id", id))
+	for _, source := range sources {
+		if !deliver(ctx, source, NewBarrier(id)) {
+			cp.abort(id)
+			return id, ctx.Err()
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		cp.abort(id)
+		err = ctx.Err()
+	case <-done:
+	}
+	return
+}
+
+// abort forgets a checkpoint that is not going to complete, so the states
+// saved late for it are rejected
+func (cp *Checkpointer) abort(id int64) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	if _, ok := cp.pending[id]; ok {
+		delete(cp.pending, id)
+		delete(cp.done, id)
+		cp.logger.Warn("checkpoint aborted", Int64("checkpoint_id", id))
+	}
+}
+
+func (cp *Checkpointer) save(id int64, componentID string, state []byte) (err error) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	if _, ok := cp.pending[id]; !ok {
+		return fmt.Errorf("checkpoint %d is not in progress", id)
+	}
+	err = ioutil.WriteFile(cp.statePath(id, componentID), state, 0644)
+	if err != nil {
+		return
+	}
+	return cp.saved(id, componentID)
+}
+
+func (cp *Checkpointer) saved(id int64, componentID string) (err error) {
+	pending := cp.pending[id]
+	delete(pending, componentID)
+	if len(pending) == 0 {
+		err = cp.complete(id)
+	}
+	return
+}
+
+func (cp *Checkpointer) complete(id int64) (err error) {
+	err = ioutil.WriteFile(filepath.Join(cp.checkpointDir(id), checkpointComplete), nil, 0644)
+	close(cp.done[id])
+	delete(cp.pending, id)
+	delete(cp.done, id)
+	cp.logger.Info("checkpoint complete", Int64("checkpoint_id", id))
+	return
+}
+
+// Latest returns the last complete checkpoint
+func (cp *Checkpointer) Latest() (id int64, err error) {
+	ids, err := cp.checkpoints(true)
+	if err != nil {
+		return
+	}
+	if len(ids) == 0 {
+		return 0, ErrNoCheckpoint
+	}
+	return ids[len(ids)-1], nil
+}
+
+// Restore restores the registered components state from the checkpoint.
+// The components that were not part of the checkpoint are left untouched
+func (cp *Checkpointer) Restore(id int64) (err error) {
+	cp.mux.Lock()
+	defer cp.mux.Unlock()
+
+	for componentID, state := range cp.components {
+		data, err := ioutil.ReadFile(cp.statePath(id, componentID))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = state.Restore(data); err != nil {
+			return fmt.Errorf("restoring component %s: %s", componentID, err)
+		}
+	}
+	cp.logger.Info("checkpoint restored", Int64("checkpoint_id", id))
+	return
+}
+
+// RestoreLatest restores the last complete checkpoint, if any
+func (cp *Checkpointer) RestoreLatest() (id int64, err error) {
+	id, err = cp.Latest()
+	if err != nil {
+		return
+	}
+	err = cp.Restore(id)
+	return
+}
+
+func (cp *Checkpointer) checkpoints(complete bool) (ids []int64, err error) {
+	entries, err := ioutil.ReadDir(cp.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), checkpointDirPrefix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(entry.Name(), checkpointDirPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if complete {
+			if _, err := os.Stat(filepath.Join(cp.dir, entry.Name(), checkpointComplete)); err != nil {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return
+}
+
+func (cp *Checkpointer) checkpointDir(id int64) string {
+	return filepath.Join(cp.dir, fmt.Sprintf("%s%08d", checkpointDirPrefix, id))
+}
+
+func (cp *Checkpointer) statePath(id int64, componentID string) string {
+	return filepath.Join(cp.checkpointDir(id), url.PathEscape(componentID)+checkpointStateExt)
+}
+
+// encodePackages encodes the packages buffered by a component for its
+// snapshot. The nil ones are kept as nil
+func encodePackages(codec Codec, informationPackages []*InformationPackage) (data [][]byte, err error) {
+	if codec == nil {
+		return nil, errors.New("snapshotting buffered packages requires a codec")
+	}
+	data = make([][]byte, len(informationPackages))
+	for k, informationPackage := range informationPackages {
+		if informationPackage == nil {
+			continue
+		}
+		if data[k], err = codec.Encode(informationPackage); err != nil {
+			return nil, fmt.Errorf("encoding package %s: %w", informationPackage.ID, err)
+		}
+	}
+	return
+}
+
+func decodePackages(codec Codec, data [][]byte) (informationPackages []*InformationPackage, err error) {
+	if codec == nil {
+		return nil, errors.New("restoring buffered packages requires a codec")
+	}
+	informationPackages = make([]*InformationPackage, len(data))
+	for k, d := range data {
+		if d == nil {
+			continue
+		}
+		if informationPackages[k], err = codec.Decode(d); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func newBarrierAligner(inputs int) *barrierAligner {
+	return &barrierAligner{
+		inputs:  inputs,
+		arrived: make(map[int64]int),
+		aligned: make(map[int64]chan struct{}),
+	}
+}
+
+func (ba *barrierAligner) setInputs(inputs int) {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+
+	ba.inputs = inputs
+}
+
+// arrive records the barrier arrival from one of the inputs. The last input
+// to arrive must forward the barrier, while the others must wait for the
+// returned channel to be closed before going on with their packages
+func (ba *barrierAligner) arrive(id int64) (last bool, aligned chan struct{}) {
+	ba.mux.Lock()
+	defer ba.mux.Unlock()
+
+	aligned, ok := ba.aligned[id]
+	if !ok {
+		aligned = make(chan struct{})
+		ba.aligned[id] = aligned
+	}
+	ba.arrived[id]++
+	if ba.arrived[id] < ba.inputs {
+		return false, aligned
+	}
+	close(aligned)
+	delete(ba.arrived, id)
+	delete(ba.aligned, id)
+	return true, aligned
+}
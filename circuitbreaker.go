@@ -0,0 +1,254 @@
+package fbp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+var ErrCircuitOpen = errors.New("circuit is open")
+
+type (
+	CircuitState int
+
+	// CircuitBreakerSpec trips the breaker when, out of the last Window
+	// calls and having at least MinCalls of them, the failed ones reach
+	// FailureRate. The breaker stays open for OpenTimeout, and then lets
+	// HalfOpenCalls calls through to probe the task. If all of them succeed
+	// it closes, otherwise it opens again
+	CircuitBreakerSpec struct {
+		Window        int
+		MinCalls      int
+		FailureRate   float64
+		OpenTimeout   time.Duration
+		HalfOpenCalls int
+	}
+
+	// CircuitBreaker is a Task wrapping another one. While it's open, the
+	// packages aren't given to the task, but sent to the out channel of the
+	// fallback port, or failed with ErrCircuitOpen when there isn't any. The
+	// error is permanent, so the retries of the component don't wait for a
+	// circuit that stays open longer than them
+	CircuitBreaker struct {
+		id       string
+		task     Task
+		spec     CircuitBreakerSpec
+		fallback *Port
+		clock    Clock
+		events   *EventBus
+
+		mux           sync.Mutex
+		state         CircuitState
+		openedAt      time.Time
+		results       []bool
+		next          int
+		calls         int
+		failures      int
+		trials        int
+		trialsOK      int
+		onStateChange []func(id string, from, to CircuitState)
+		changes       []circuitChange
+	}
+
+	circuitChange struct {
+		from, to CircuitState
+	}
+)
+
+var (
+	_ Task        = &CircuitBreaker{}
+	_ ContextTask = &CircuitBreaker{}
+	_ ClockAware  = &CircuitBreaker{}
+	_ EventAware  = &CircuitBreaker{}
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+func NewCircuitBreaker(id string, task Task, spec CircuitBreakerSpec, fallback *Port) (cb *CircuitBreaker, err error) {
+	if spec.Window < 1 || spec.FailureRate <= 0 || spec.FailureRate > 1 {
+		return nil, errors.New("circuit breaker requires a window and a failure rate between 0 and 1")
+	}
+	if spec.MinCalls < 1 {
+		spec.MinCalls = 1
+	}
+	if spec.HalfOpenCalls < 1 {
+		spec.HalfOpenCalls = 1
+	}
+	cb = &CircuitBreaker{
+		id:       id,
+		task:     task,
+		spec:     spec,
+		fallback: fallback,
+		clock:    RealClock{},
+		results:  make([]bool, spec.Window),
+	}
+	return
+}
+
+// OnStateChange adds a function called each time the breaker changes its
+// state. It's called from the goroutine running the task
+func (cb *CircuitBreaker) OnStateChange(f func(id string, from, to CircuitState)) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.onStateChange = append(cb.onStateChange, f)
+}
+
+func (cb *CircuitBreaker) SetClock(clock Clock) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.clock = clock
+}
+
+// SetEventBus makes the breaker publish its state changes to the bus
+func (cb *CircuitBreaker) SetEventBus(bus *EventBus) {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	cb.events = bus
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	return cb.state
+}
+
+func (cb *CircuitBreaker) Do(in *InformationPackage) (out *InformationPackage, err error) {
+	return cb.DoContext(context.Background(), in)
+}
+
+func (cb *CircuitBreaker) DoContext(ctx context.Context, in *InformationPackage) (out *InformationPackage, err error) {
+	if !cb.allow() {
+		if cb.fallback == nil {
+			return nil, Permanent(fmt.Errorf("circuit breaker %s, package %s: %w", cb.id, in.ID, ErrCircuitOpen))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case cb.fallback.Out <- in:
+		}
+		return nil, nil
+	}
+
+	// A panic is recorded as a failure, or a half open circuit would wait
+	// for the trial forever, and it's left to the component to recover it
+	defer func() {
+		if e := recover(); e != nil {
+			cb.record(false)
+			panic(e)
+		}
+	}()
+	if task, ok := cb.task.(ContextTask); ok {
+		out, err = task.DoContext(ctx, in)
+	} else {
+		out, err = cb.task.Do(in)
+	}
+	cb.record(err == nil)
+	return
+}
+
+// allow reports whether a call can go through, opening the half-open state
+// when the open timeout has passed
+func (cb *CircuitBreaker) allow() bool {
+	defer cb.notify()
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	if cb.state == CircuitOpen {
+		if cb.clock.Now().Sub(cb.openedAt) < cb.spec.OpenTimeout {
+			return false
+		}
+		cb.setState(CircuitHalfOpen)
+	}
+	if cb.state == CircuitHalfOpen {
+		if cb.trials >= cb.spec.HalfOpenCalls {
+			return false
+		}
+		cb.trials++
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) record(success bool) {
+	defer cb.notify()
+	cb.mux.Lock()
+	defer cb.mux.Unlock()
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		if !success {
+			cb.setState(CircuitOpen)
+			return
+		}
+		cb.trialsOK++
+		if cb.trialsOK >= cb.spec.HalfOpenCalls {
+			cb.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		if cb.calls == len(cb.results) {
+			if !cb.results[cb.next] {
+				cb.failures--
+			}
+		} else {
+			cb.calls++
+		}
+		cb.results[cb.next] = success
+		cb.next = (cb.next + 1) % len(cb.results)
+		if !success {
+			cb.failures++
+		}
+		if cb.calls >= cb.spec.MinCalls && float64(cb.failures)/float64(cb.calls) >= cb.spec.FailureRate {
+			cb.setState(CircuitOpen)
+		}
+	}
+}
+
+// setState must be called holding the lock
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	from := cb.state
+	cb.state = state
+	cb.trials, cb.trialsOK = 0, 0
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = cb.clock.Now()
+	case CircuitClosed:
+		cb.calls, cb.failures, cb.next = 0, 0, 0
+	}
+	cb.changes = append(cb.changes, circuitChange{from: from, to: state})
+}
+
+// notify calls the state change functions out of the lock, so they can use the breaker
+func (cb *CircuitBreaker) notify() {
+	cb.mux.Lock()
+	changes, fs, events := cb.changes, cb.onStateChange, cb.events
+	cb.changes = nil
+	cb.mux.Unlock()
+
+	for _, change := range changes {
+		events.Publish(Event{Kind: CircuitStateChanged, Source: cb.id, Detail: change.from.String() + " -> " + change.to.String()})
+		for _, f := range fs {
+			f(cb.id, change.from, change.to)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package fbp_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+type failingTask struct {
+	calls int
+}
+
+func (ft *failingTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	ft.calls++
+	return nil, errors.New("failing")
+}
+
+func TestCircuitOpenNotRetried(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failing := &failingTask{}
+	breaker, err := fbp.NewCircuitBreaker("breaker", failing, fbp.CircuitBreakerSpec{Window: 1, FailureRate: 1, OpenTimeout: time.Hour}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorPort := fbp.NewPort("errors", nil, make(chan *fbp.InformationPackage, 1))
+	component := fbp.NewComponentWith(ctx, "breaker", breaker,
+		fbp.WithRetryPolicy(fbp.RetryPolicy{MaxAttempts: 3}), fbp.WithErrorPort(errorPort))
+	component.Stream()
+
+	// The first attempt opens the circuit, and the second one is not retried
+	component.Port().In <- fbp.NewInformationPackage("ip", nil)
+	select {
+	case ip := <-errorPort.Out:
+		if got := ip.Header(fbp.AttemptsHeader); got != "2" {
+			t.Errorf("got %s attempts, want 2", got)
+		}
+		if got := ip.Header(fbp.ErrorHeader); !strings.Contains(got, fbp.ErrCircuitOpen.Error()) {
+			t.Errorf("got error %q, want %v", got, fbp.ErrCircuitOpen)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("package not sent to the error port")
+	}
+	if failing.calls != 1 {
+		t.Errorf("got %d calls, want 1", failing.calls)
+	}
+}
@@ -0,0 +1,240 @@
+package fbp
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock is the source of time of the time based components, so they can
+	// be driven by a FakeClock
+	Clock interface {
+		Now() time.Time
+		After(d time.Duration) <-chan time.Time
+		Sleep(d time.Duration)
+		NewTimer(d time.Duration) Timer
+		NewTicker(d time.Duration) Ticker
+	}
+
+	Timer interface {
+		C() <-chan time.Time
+		Stop() bool
+		Reset(d time.Duration) bool
+	}
+
+	Ticker interface {
+		C() <-chan time.Time
+		Stop()
+	}
+
+	// ClockAware is implemented by the tasks that need a clock. A network
+	// gives them its own
+	ClockAware interface {
+		SetClock(clock Clock)
+	}
+
+	// RealClock is the wall clock
+	RealClock struct{}
+
+	realTimer struct {
+		*time.Timer
+	}
+
+	realTicker struct {
+		*time.Ticker
+	}
+
+	fakeTicker struct {
+		*fakeWaiter
+	}
+
+	// FakeClock only moves when it's told to. Its timers and tickers fire
+	// as its time goes past them
+	FakeClock struct {
+		mux     sync.Mutex
+		cond    *sync.Cond
+		now     time.Time
+		waiters []*fakeWaiter
+	}
+
+	fakeWaiter struct {
+		clock  *FakeClock
+		at     time.Time
+		period time.Duration
+		c      chan time.Time
+	}
+)
+
+var _ Clock = RealClock{}
+var _ Clock = &FakeClock{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (RealClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	fc := &FakeClock{now: now}
+	fc.cond = sync.NewCond(&fc.mux)
+	return fc
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+	return fc.now
+}
+
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	return fc.NewTimer(d).C()
+}
+
+func (fc *FakeClock) Sleep(d time.Duration) {
+	<-fc.After(d)
+}
+
+func (fc *FakeClock) NewTimer(d time.Duration) Timer {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	w := &fakeWaiter{clock: fc, c: make(chan time.Time, 1)}
+	fc.schedule(w, fc.now.Add(d))
+	return w
+}
+
+func (fc *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	w := &fakeWaiter{clock: fc, period: d, c: make(chan time.Time, 1)}
+	fc.schedule(w, fc.now.Add(d))
+	return fakeTicker{w}
+}
+
+// Advance moves the clock forward, firing in order the timers and tickers
+// due in the meantime
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	until := fc.now.Add(d)
+	for len(fc.waiters) > 0 && !fc.waiters[0].at.After(until) {
+		w := fc.waiters[0]
+		fc.waiters = fc.waiters[1:]
+		fc.now = w.at
+		// Like the time ones, a slow reader misses ticks
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			fc.schedule(w, w.at.Add(w.period))
+		}
+	}
+	fc.now = until
+}
+
+// BlockUntil waits until there are n timers and tickers waiting for the
+// clock, so it can be advanced once the components are ready
+func (fc *FakeClock) BlockUntil(n int) {
+	fc.BlockUntilContext(context.Background(), n)
+}
+
+// BlockUntilContext is BlockUntil giving up when the context is done
+func (fc *FakeClock) BlockUntilContext(ctx context.Context, n int) error {
+	// The waiting below is woken up when the context is done, which can't
+	// happen before it starts waiting, as it holds the lock until then
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			fc.mux.Lock()
+			defer fc.mux.Unlock()
+			fc.cond.Broadcast()
+		}
+	}()
+
+	fc.mux.Lock()
+	defer fc.mux.Unlock()
+
+	for len(fc.waiters) < n {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fc.cond.Wait()
+	}
+	return nil
+}
+
+func (fc *FakeClock) schedule(w *fakeWaiter, at time.Time) {
+	w.at = at
+	k := sort.Search(len(fc.waiters), func(k int) bool { return fc.waiters[k].at.After(at) })
+	fc.waiters = append(fc.waiters, nil)
+	copy(fc.waiters[k+1:], fc.waiters[k:])
+	fc.waiters[k] = w
+	fc.cond.Broadcast()
+}
+
+// unschedule reports whether the waiter was still pending
+func (fc *FakeClock) unschedule(w *fakeWaiter) bool {
+	for k, waiter := range fc.waiters {
+		if waiter == w {
+			fc.waiters = append(fc.waiters[:k], fc.waiters[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mux.Lock()
+	defer w.clock.mux.Unlock()
+	return w.clock.unschedule(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.clock.mux.Lock()
+	defer w.clock.mux.Unlock()
+
+	active := w.clock.unschedule(w)
+	w.clock.schedule(w, w.clock.now.Add(d))
+	return active
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
@@ -0,0 +1,205 @@
+package fbp
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/theskyinflames/set"
+)
+
+type (
+	// Codec serializes information packages to cross process boundaries
+	Codec interface {
+		Encode(ip *InformationPackage) ([]byte, error)
+		Decode(data []byte) (*InformationPackage, error)
+	}
+
+	// GobCodec encodes the IP status items with encoding/gob, so the
+	// concrete types stored in the status must be registered with gob.Register
+	GobCodec struct{}
+
+	// JSONCodec encodes the IP status items as JSON, along with the name of
+	// their type. The items whose type has been registered with Register are
+	// decoded to it, and the rest to the generic JSON values. The items are
+	// sorted, so the same package is always encoded the same way
+	JSONCodec struct {
+		mux   sync.RWMutex
+		types map[string]reflect.Type
+	}
+
+	jsonPackage struct {
+		ID      string            `json:"id"`
+		Items   []jsonItem        `json:"items,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Barrier int64             `json:"barrier,omitempty"`
+		Seq     uint64            `json:"seq,omitempty"`
+	}
+
+	jsonItem struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	}
+
+	wirePackage struct {
+		ID      string
+		Items   []interface{}
+		Headers map[string]string
+		Barrier int64
+		Seq     uint64
+	}
+)
+
+func NewGobCodec() *GobCodec {
+	return &GobCodec{}
+}
+
+func (gc *GobCodec) Encode(ip *InformationPackage) (data []byte, err error) {
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(toWirePackage(ip))
+	if err != nil {
+		return
+	}
+	data = buf.Bytes()
+	return
+}
+
+func (gc *GobCodec) Decode(data []byte) (ip *InformationPackage, err error) {
+	var wp wirePackage
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&wp)
+	if err != nil {
+		return
+	}
+	ip = fromWirePackage(wp)
+	return
+}
+
+func NewJSONCodec() *JSONCodec {
+	return &JSONCodec{
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register makes the items of the same type as value be decoded to it
+func (jc *JSONCodec) Register(value interface{}) {
+	jc.mux.Lock()
+	defer jc.mux.Unlock()
+
+	t := reflect.TypeOf(value)
+	jc.types[t.String()] = t
+}
+
+func (jc *JSONCodec) Encode(ip *InformationPackage) (data []byte, err error) {
+	jp := jsonPackage{
+		ID:      ip.ID,
+		Headers: ip.Headers,
+		Barrier: ip.Barrier,
+		Seq:     ip.Seq,
+	}
+	for _, item := range statusItems(ip.Status) {
+		value, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		jp.Items = append(jp.Items, jsonItem{Type: reflect.TypeOf(item).String(), Value: value})
+	}
+	sort.Slice(jp.Items, func(i, j int) bool {
+		if jp.Items[i].Type != jp.Items[j].Type {
+			return jp.Items[i].Type < jp.Items[j].Type
+		}
+		return string(jp.Items[i].Value) < string(jp.Items[j].Value)
+	})
+	return json.Marshal(jp)
+}
+
+func (jc *JSONCodec) Decode(data []byte) (ip *InformationPackage, err error) {
+	var jp jsonPackage
+	if err = json.Unmarshal(data, &jp); err != nil {
+		return
+	}
+
+	jc.mux.RLock()
+	defer jc.mux.RUnlock()
+
+	items := make([]interface{}, len(jp.Items))
+	for k, item := range jp.Items {
+		t, ok := jc.types[item.Type]
+		if !ok {
+			if err = json.Unmarshal(item.Value, &items[k]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		v := reflect.New(t)
+		if err = json.Unmarshal(item.Value, v.Interface()); err != nil {
+			return nil, fmt.Errorf("decoding %s: %s", item.Type, err)
+		}
+		items[k] = v.Elem().Interface()
+	}
+	ip = &InformationPackage{
+		ID:      jp.ID,
+		Status:  newStatus(items),
+		Headers: jp.Headers,
+		Barrier: jp.Barrier,
+		Seq:     jp.Seq,
+	}
+	return
+}
+
+func toWirePackage(ip *InformationPackage) wirePackage {
+	return wirePackage{
+		ID:      ip.ID,
+		Items:   statusItems(ip.Status),
+		Headers: ip.Headers,
+		Barrier: ip.Barrier,
+		Seq:     ip.Seq,
+	}
+}
+
+func fromWirePackage(wp wirePackage) *InformationPackage {
+	return &InformationPackage{
+		ID:      wp.ID,
+		Status:  newStatus(wp.Items),
+		Headers: wp.Headers,
+		Barrier: wp.Barrier,
+		Seq:     wp.Seq,
+	}
+}
+
+func statusItems(s *set.Set) (items []interface{}) {
+	if s == nil {
+		return
+	}
+	next := s.Iterator()
+	for c := s.Count(); c > 0; c-- {
+		item, _ := next()
+		items = append(items, item)
+	}
+	return
+}
+
+// newStatus rebuilds a status set. The set does not expose its keys, so items
+// implementing KeyGetter keep their own key and the rest are keyed by position
+func newStatus(items []interface{}) *set.Set {
+	s := &set.Set{
+		RWMutex: sync.RWMutex{},
+	}
+	for k, item := range items {
+		key := itemKey(k)
+		if kg, ok := item.(KeyGetter); ok {
+			key = kg.Key()
+		}
+		s.Add(key, item)
+	}
+	return s
+}
+
+func itemKey(k int) func() string {
+	return func() string {
+		return fmt.Sprintf("item_%d", k)
+	}
+}
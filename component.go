@@ -2,25 +2,91 @@ package fbp
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ForwardOnError handles the task errors, still sending the task output if there is any
+	ForwardOnError ErrorPolicy = iota
+	// DropOnError handles the task errors, discarding the task output
+	DropOnError
+	// StopOnError handles the task errors and stops the component
+	StopOnError
+)
 
-	"go.uber.org/zap"
+var (
+	ErrTaskTimeout = errors.New("task timed out")
+	ErrTaskPanic   = errors.New("task panicked")
+	ErrStreamTask  = errors.New("stream task must be run by a component")
 )
 
 type (
 	Task interface {
 		Do(in *InformationPackage) (out *InformationPackage, err error)
 	}
-)
 
-func NewComponent(ctx context.Context, id string, port *Port, task Task, errorHandler *ErrorHandler, logger *zap.Logger) *Component {
-	return &Component{
-		ctx:          ctx,
-		id:           id,
-		port:         port,
-		task:         task,
-		errorHandler: errorHandler,
-		logger:       logger,
+	// ContextTask is a task that receives a context for each package. It's
+	// canceled when the component stops or the package times out
+	ContextTask interface {
+		DoContext(ctx context.Context, in *InformationPackage) (out *InformationPackage, err error)
+	}
+
+	// ContextTaskFunc adapts a function to be both a Task and a ContextTask
+	ContextTaskFunc func(ctx context.Context, in *InformationPackage) (out *InformationPackage, err error)
+
+	// streamTask is a task that reads and sends the packages of its component
+	// by itself, as it doesn't return one package for each package it
+	// receives. The component runs it instead of its workers, so the worker
+	// options, like the concurrency, the timeout or the retries, don't apply.
+	// It returns when the in channel is closed or the component stops
+	streamTask interface {
+		Task
+		stream(c *Component)
+	}
+
+	// streamer gives the stream tasks the Do of a Task, which their component
+	// never calls
+	streamer struct{}
+
+	ErrorPolicy int
+
+	taskResult struct {
+		out *InformationPackage
+		err error
+	}
+
+	// workerPool is what the workers of a streaming component share
+	workerPool struct {
+		work     chan *InformationPackage
+		stop     chan struct{}
+		haltOnce sync.Once
+		inflight sync.WaitGroup
+		running  sync.WaitGroup
+		quits    []chan struct{}
+		closed   bool
 	}
+)
+
+// latencyWeight is the weight of each package in the average task latency
+const latencyWeight = 0.2
+
+func (streamer) Do(in *InformationPackage) (out *InformationPackage, err error) {
+	return nil, ErrStreamTask
+}
+
+func (f ContextTaskFunc) Do(in *InformationPackage) (out *InformationPackage, err error) {
+	return f(context.Background(), in)
+}
+
+func (f ContextTaskFunc) DoContext(ctx context.Context, in *InformationPackage) (out *InformationPackage, err error) {
+	return f(ctx, in)
+}
+
+func NewComponent(ctx context.Context, id string, port *Port, task Task, errorHandler *ErrorHandler, logger Logger) *Component {
+	return NewComponentWith(ctx, id, task, WithPort(port), WithErrorHandler(errorHandler), WithLogger(logger))
 }
 
 type Component struct {
@@ -28,31 +94,403 @@ type Component struct {
 	port         *Port
 	task         Task
 	errorHandler *ErrorHandler
-	logger       *zap.Logger
+	logger       Logger
 	ctx          context.Context
+	checkpointer *Checkpointer
+	clock        Clock
+	concurrency  int
+	timeout      time.Duration
+	errorPolicy  ErrorPolicy
+	retryPolicy  *RetryPolicy
+	errorPort    *Port
+	events       *EventBus
+	shuffle      *shuffler
+	drain        chan struct{}
+	drainOnce    sync.Once
+	done         chan struct{}
+
+	workerMux sync.Mutex
+	pool      *workerPool
+
+	statsMux sync.Mutex
+	latency  time.Duration
+	measured bool
+}
+
+// SetTimeout bounds how long the task can take for each package. A timed
+// out package is handled as an ErrTaskTimeout error. The tasks that aren't a
+// ContextTask can't be canceled, so they keep running in the background until
+// they return, and a retry waits for them before the next attempt. It must be
+// set before streaming
+func (c *Component) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetConcurrency sets how many packages the component processes at the same
+// time. With more than one the packages can be sent out of order. It can be
+// changed while streaming: the workers left over stop once they are done
+// with their package
+func (c *Component) SetConcurrency(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	c.workerMux.Lock()
+	defer c.workerMux.Unlock()
+
+	c.concurrency = workers
+	p := c.pool
+	if p == nil || p.closed {
+		return
+	}
+	for len(p.quits) < workers {
+		c.startWorker(p)
+	}
+	for len(p.quits) > workers {
+		close(p.quits[len(p.quits)-1])
+		p.quits = p.quits[:len(p.quits)-1]
+	}
+}
+
+// Concurrency returns how many packages the component processes at the same time
+func (c *Component) Concurrency() int {
+	c.workerMux.Lock()
+	defer c.workerMux.Unlock()
+
+	return c.concurrency
+}
+
+// Latency returns the moving average of how long the task takes for each
+// package, retries included
+func (c *Component) Latency() time.Duration {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+
+	return c.latency
+}
+
+func (c *Component) measure(d time.Duration) {
+	c.statsMux.Lock()
+	defer c.statsMux.Unlock()
+
+	if !c.measured {
+		c.latency, c.measured = d, true
+		return
+	}
+	c.latency += time.Duration(latencyWeight * float64(d-c.latency))
+}
+
+// Port returns the port the component reads from and writes to
+func (c *Component) Port() *Port {
+	return c.port
+}
+
+// SetErrorPolicy sets what the component does after handling a task error.
+// It must be set before streaming
+func (c *Component) SetErrorPolicy(policy ErrorPolicy) {
+	c.errorPolicy = policy
+}
+
+// SetClock sets the clock the timeouts are measured with. It must be set
+// before streaming
+func (c *Component) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+// SetEventBus makes the component publish its lifecycle events to the bus.
+// It must be set before streaming
+func (c *Component) SetEventBus(bus *EventBus) {
+	c.events = bus
+}
+
+// SetCheckpointer makes the component save its state when it receives a
+// checkpoint barrier, if its task is Stateful. It must be set before streaming
+func (c *Component) SetCheckpointer(checkpointer *Checkpointer) {
+	c.checkpointer = checkpointer
+	if state, ok := c.task.(Stateful); ok {
+		checkpointer.Register(c.id, state)
+	}
+}
+
+func (c *Component) checkpoint(barrier *InformationPackage) {
+	state, ok := c.task.(Stateful)
+	if !ok || c.checkpointer == nil {
+		return
+	}
+	data, err := state.Snapshot()
+	if err == nil {
+		err = c.checkpointer.save(barrier.Barrier, c.id, data)
+	}
+	if err != nil {
+		c.errorHandler.Handle(err)
+	}
 }
 
 func (c *Component) Stream() {
+	if task, ok := c.task.(streamTask); ok {
+		c.logger.Info("component starting")
+		c.events.Publish(Event{Kind: ComponentStarted, Source: c.id, Port: c.port.ID})
+		go func() {
+			defer close(c.done)
+			defer c.events.Publish(Event{Kind: ComponentStopped, Source: c.id, Port: c.port.ID})
+			task.stream(c)
+		}()
+		return
+	}
+
+	c.logger.Info("component starting", Int("concurrency", c.concurrency))
+	c.events.Publish(Event{Kind: ComponentStarted, Source: c.id, Port: c.port.ID})
+
+	p := &workerPool{
+		work: make(chan *InformationPackage),
+		stop: make(chan struct{}),
+	}
+	p.running.Add(1)
+	c.workerMux.Lock()
+	c.pool = p
+	for k := 0; k < c.concurrency; k++ {
+		c.startWorker(p)
+	}
+	c.workerMux.Unlock()
+
+	var drained bool
 	go func() {
-		c.logger.Info("component starting", zap.String("id", c.id), zap.String("port_id", c.port.ID))
-		for {
-			select {
-			case <-c.ctx.Done():
-				break
-			case informationPackage, ok := <-c.port.In:
-				//c.logger.Debug("component received information package", zap.String("id", c.id))
-				if !ok {
-					c.logger.Warn("in port closed", zap.String("id", c.port.ID))
-					break
-				}
-				out, err := c.task.Do(informationPackage)
-				if err != nil {
-					c.errorHandler.Handle(err)
+		defer p.running.Done()
+		drained = c.read(p)
+	}()
+	go func() {
+		p.running.Wait()
+		if drained {
+			close(c.port.Out)
+		}
+		close(c.done)
+	}()
+
+	return
+}
+
+// startWorker must be called holding the workers lock
+func (c *Component) startWorker(p *workerPool) {
+	quit := make(chan struct{})
+	p.quits = append(p.quits, quit)
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		c.work(p, quit)
+	}()
+}
+
+// closeWork tells the workers there are no more packages. No worker is
+// started afterwards
+func (c *Component) closeWork(p *workerPool) {
+	c.workerMux.Lock()
+	defer c.workerMux.Unlock()
+
+	p.closed = true
+	close(p.work)
+}
+
+func (p *workerPool) halt() {
+	p.haltOnce.Do(func() { close(p.stop) })
+}
+
+// Drain makes the component stop once it has processed the packages waiting
+// in its in channel, and waits for it. Then the out channel of its port is
+// closed, so the connections reading from it end after forwarding the rest.
+// Nothing must be sent to the in channel after calling it. The components
+// running a stream task, like the routers or the batchers, are not drained:
+// it waits until their in channel, or the in ports of a join, are closed, and
+// their out channel is left open
+func (c *Component) Drain(ctx context.Context) error {
+	c.drainOnce.Do(func() { close(c.drain) })
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return nil
+	}
+}
+
+// read hands the packages to the workers, until the component stops or it's
+// drained, reporting the later
+func (c *Component) read(p *workerPool) (drained bool) {
+	defer c.events.Publish(Event{Kind: ComponentStopped, Source: c.id, Port: c.port.ID})
+	defer c.closeWork(p)
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-c.drain:
+			for {
+				select {
+				case informationPackage, ok := <-c.port.In:
+					if !ok || !c.accept(p, informationPackage) {
+						return false
+					}
+				default:
+					c.logger.Info("component drained")
+					return true
 				}
-				c.port.Out <- out
 			}
+		case informationPackage, ok := <-c.port.In:
+			//c.logger.Debug("component received information package")
+			if !ok {
+				c.logger.Warn("in port closed")
+				c.events.Publish(Event{Kind: PortClosed, Source: c.id, Port: c.port.ID})
+				return
+			}
+			if !c.accept(p, informationPackage) {
+				return
+			}
+		}
+	}
+}
+
+// accept hands a package to the workers, reporting whether the component
+// must go on. The barriers wait for the packages handed before them to be
+// done, so the task state is checkpointed consistently
+func (c *Component) accept(p *workerPool, informationPackage *InformationPackage) bool {
+	if informationPackage.IsBarrier() {
+		p.inflight.Wait()
+		c.checkpoint(informationPackage)
+		return c.send(informationPackage)
+	}
+	p.inflight.Add(1)
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-p.stop:
+		return false
+	case p.work <- informationPackage:
+		return true
+	}
+}
+
+// work processes packages until the component stops or the worker quits
+func (c *Component) work(p *workerPool, quit chan struct{}) {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-quit:
+			return
+		case informationPackage, ok := <-p.work:
+			if !ok {
+				return
+			}
+			running := c.process(informationPackage)
+			p.inflight.Done()
+			if !running {
+				p.halt()
+				return
+			}
+		}
+	}
+}
+
+// process runs the task for a package and sends its output, reporting
+// whether the component must go on
+func (c *Component) process(in *InformationPackage) bool {
+	c.shuffle.delay()
+	start := c.clock.Now()
+	attempted, out, err := c.retry(in)
+	c.measure(c.clock.Now().Sub(start))
+	if c.ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		c.events.Publish(Event{Kind: ErrorRaised, Source: c.id, Port: c.port.ID, PackageID: in.ID, Err: err})
+		if c.errorPort != nil {
+			if !c.deadLetter(attempted, err) {
+				return false
+			}
+			out = nil
+		} else {
+			c.errorHandler.handle(err, String("component_id", c.id), String("port_id", c.port.ID), String("ip_id", in.ID))
+		}
+		switch {
+		case c.errorPolicy == StopOnError:
+			c.logger.Warn("component stopped on error")
+			return false
+		case c.errorPolicy == DropOnError && c.errorPort == nil:
+			c.events.Publish(Event{Kind: PackageDropped, Source: c.id, Port: c.port.ID, PackageID: in.ID, Err: err})
+			return true
+		case c.errorPort != nil:
+			return true
+		}
+	}
+	if out == nil {
+		return true
+	}
+	out.inherit(in)
+	if c.retryPolicy != nil {
+		out.SetHeader(AttemptsHeader, attempted.Header(AttemptsHeader))
+	}
+	return c.send(out)
+}
+
+func (c *Component) send(out *InformationPackage) bool {
+	return c.sendTo(c.port, out)
+}
+
+// sendTo sends a package by the out channel of another port, like the routes
+// of a router or the error port
+func (c *Component) sendTo(port *Port, out *InformationPackage) bool {
+	select {
+	case <-c.ctx.Done():
+		return false
+	case port.Out <- out:
+		return true
+	}
+}
+
+// do runs the task for a package, within the component timeout if there is any.
+// When the task times out, the returned running channel gets its result once
+// it ends, as a task not taking a context can't be stopped
+func (c *Component) do(in *InformationPackage) (out *InformationPackage, running <-chan taskResult, err error) {
+	if c.timeout <= 0 {
+		out, err = c.call(c.ctx, in)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	timer := c.clock.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	done := make(chan taskResult, 1)
+	go func() {
+		out, err := c.call(ctx, in)
+		done <- taskResult{out: out, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.out, nil, result.err
+	case <-timer.C():
+		return nil, done, fmt.Errorf("component %s, package %s: %w", c.id, in.ID, ErrTaskTimeout)
+	case <-c.ctx.Done():
+		return nil, done, c.ctx.Err()
+	}
+}
+
+// call runs the task, recovering it from panics so the component goes on
+// with the next package
+func (c *Component) call(ctx context.Context, in *InformationPackage) (out *InformationPackage, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			out, err = nil, fmt.Errorf("component %s, package %s: %w: %v", c.id, in.ID, ErrTaskPanic, e)
+			c.logger.Error("task panicked", String("ip_id", in.ID), Any("panic", e))
+			c.events.Publish(Event{Kind: ComponentRestarted, Source: c.id, Port: c.port.ID, PackageID: in.ID, Err: err})
 		}
 	}()
 
-	return
+	if task, ok := c.task.(ContextTask); ok {
+		return task.DoContext(ctx, in)
+	}
+	return c.task.Do(in)
 }
@@ -3,60 +3,251 @@ package fbp
 import (
 	"context"
 	"errors"
-
-	"go.uber.org/zap"
+	"fmt"
+	"sync"
+	"sync/atomic"
 )
 
-func NewConnection(ctx context.Context, id string, logger *zap.Logger) *Connection {
-	return &Connection{
-		ctx:    ctx,
-		ID:     id,
-		logger: logger,
-	}
+func NewConnection(ctx context.Context, id string, logger Logger) *Connection {
+	return NewConnectionWith(ctx, id, WithLogger(logger))
 }
 
 type Connection struct {
-	logger *zap.Logger
-	ctx    context.Context
-	ID     string
+	logger    Logger
+	ctx       context.Context
+	ID        string
+	onPackage func(from *Port, to *Port, informationPackage *InformationPackage)
+	events    *EventBus
+	shuffle   *shuffler
+	clock     Clock
+
+	tapMux   sync.RWMutex
+	recorder *Recorder
+	taps     []*edgeTap
+
+	destMux      sync.Mutex
+	destinations []Port
+	rebalance    bool
+	generation   *dispatchGeneration
+
+	holdMux sync.Mutex
+	held    map[edgeKey]chan struct{}
 }
 
-func (c *Connection) StreamSingle(from *Port, to *Port) (err error) {
-	go func() {
-		c.logger.Info("starting connection", zap.String("id", c.ID))
-		for {
-			select {
-			case <-c.ctx.Done():
-				break
-			case informationPackage, ok := <-from.Out:
-				//c.logger.Debug("connection id received package", zap.String("id", c.ID), zap.Bool("ok", ok))
-				if !ok {
-					break
-				}
-				to.In <- informationPackage
+// dispatchGeneration tracks the sends to a set of destinations of a dispatch
+// connection, so they can be aborted when the destinations change
+type dispatchGeneration struct {
+	changed chan struct{}
+	sending sync.WaitGroup
+}
+
+// edgeKey identifies the packages going from a port to another
+type edgeKey struct {
+	from chan *InformationPackage
+	to   chan *InformationPackage
+}
+
+// edgeTap is a subscription to the packages crossing a connection
+type edgeTap struct {
+	records chan Record
+	every   uint64
+	seen    uint64
+}
+
+// OnPackage sets a function called with every package the connection
+// forwards. It must be set before starting to stream
+func (c *Connection) OnPackage(f func(from *Port, to *Port, informationPackage *InformationPackage)) {
+	c.onPackage = f
+}
+
+// SetEventBus makes the connection publish its events to the bus. It must be
+// set before starting to stream
+func (c *Connection) SetEventBus(bus *EventBus) {
+	c.events = bus
+}
+
+// Record makes the recorder record the packages the connection forwards,
+// from then on. A nil recorder stops recording. It can be called while streaming
+func (c *Connection) Record(recorder *Recorder) {
+	c.tapMux.Lock()
+	defer c.tapMux.Unlock()
+
+	c.recorder = recorder
+}
+
+// Subscribe returns a channel receiving one of every every packages the
+// connection forwards from now on, and the function to unsubscribe, which
+// closes the channel. The packages are copies, sent without blocking, so
+// they are dropped while the channel buffer is full. It can be called while
+// streaming
+func (c *Connection) Subscribe(buffer int, every int) (records <-chan Record, unsubscribe func()) {
+	t := newEdgeTap(buffer, every)
+	c.attach(t)
+
+	var once sync.Once
+	return t.records, func() {
+		once.Do(func() {
+			c.detach(t)
+			close(t.records)
+		})
+	}
+}
+
+func newEdgeTap(buffer int, every int) *edgeTap {
+	if every < 1 {
+		every = 1
+	}
+	return &edgeTap{
+		records: make(chan Record, buffer),
+		every:   uint64(every),
+	}
+}
+
+func (c *Connection) attach(t *edgeTap) {
+	c.tapMux.Lock()
+	defer c.tapMux.Unlock()
+
+	c.taps = append(c.taps, t)
+}
+
+func (c *Connection) detach(t *edgeTap) {
+	c.tapMux.Lock()
+	defer c.tapMux.Unlock()
+
+	taps := make([]*edgeTap, 0, len(c.taps))
+	for _, tap := range c.taps {
+		if tap != t {
+			taps = append(taps, tap)
+		}
+	}
+	c.taps = taps
+}
+
+// tap hands the package to the recorder and the subscribers. It holds the
+// lock while sending, so the subscribers channels aren't closed meanwhile
+func (c *Connection) tap(from *Port, to *Port, informationPackage *InformationPackage) {
+	c.tapMux.RLock()
+	defer c.tapMux.RUnlock()
+
+	if c.recorder != nil {
+		c.recorder.record(from, to, informationPackage)
+	}
+
+	var record *Record
+	for _, t := range c.taps {
+		if atomic.AddUint64(&t.seen, 1)%t.every != 0 {
+			continue
+		}
+		if record == nil {
+			record = &Record{
+				Time:               c.clock.Now(),
+				From:               from.ID,
+				To:                 to.ID,
+				InformationPackage: ShallowClone(informationPackage),
 			}
 		}
-	}()
-	return
+		select {
+		case t.records <- *record:
+		default:
+		}
+	}
 }
 
-func (c *Connection) StreamFanOut(from *Port, to []Port) (err error) {
+// send delivers a package to the to port. A barrier that reaches an aligned
+// port before the barriers of its other inputs is not forwarded, and the
+// packages sent after it from the same port are held until it's aligned.
+// The barrier is not waited for here, so the connection can go on sending it
+// to its other destinations, which may be the ones it has to be aligned with
+func (c *Connection) send(from *Port, to *Port, informationPackage *InformationPackage) bool {
+	return c.sendUntil(from, to, informationPackage, nil) == nil
+}
+
+// sendUntil is send giving up when abort is closed before the package is
+// accepted, reporting it with errSendAborted
+func (c *Connection) sendUntil(from *Port, to *Port, informationPackage *InformationPackage, abort <-chan struct{}) (err error) {
+	if err = c.waitAligned(from, to, abort); err != nil {
+		return
+	}
+	if informationPackage.IsBarrier() && to.aligner != nil {
+		last, aligned := to.aligner.arrive(informationPackage.Barrier)
+		if !last {
+			c.hold(from, to, aligned)
+			return nil
+		}
+	}
+	c.shuffle.delay()
+	if c.onPackage != nil {
+		c.onPackage(from, to, informationPackage)
+	}
+	c.tap(from, to, informationPackage)
+	if c.events != nil {
+		select {
+		case to.In <- informationPackage:
+			return nil
+		default:
+		}
+		c.events.Publish(Event{Kind: BackpressureEngaged, Source: c.ID, Port: to.ID, PackageID: informationPackage.ID})
+	}
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-abort:
+		return errSendAborted
+	case to.In <- informationPackage:
+		c.events.Publish(Event{Kind: BackpressureReleased, Source: c.ID, Port: to.ID, PackageID: informationPackage.ID})
+		return nil
+	}
+}
+
+func (c *Connection) hold(from *Port, to *Port, aligned chan struct{}) {
+	c.holdMux.Lock()
+	defer c.holdMux.Unlock()
+
+	if c.held == nil {
+		c.held = make(map[edgeKey]chan struct{})
+	}
+	c.held[edgeKey{from: from.Out, to: to.In}] = aligned
+}
+
+// waitAligned waits for the barrier held from the from port to the to port,
+// if any, to be aligned
+func (c *Connection) waitAligned(from *Port, to *Port, abort <-chan struct{}) error {
+	key := edgeKey{from: from.Out, to: to.In}
+	c.holdMux.Lock()
+	aligned, ok := c.held[key]
+	c.holdMux.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	case <-abort:
+		return errSendAborted
+	case <-aligned:
+	}
+	c.holdMux.Lock()
+	delete(c.held, key)
+	c.holdMux.Unlock()
+	return nil
+}
+
+func (c *Connection) StreamSingle(from *Port, to *Port) (err error) {
 	go func() {
-		k := 0
-		c.logger.Info("starting fan out connection", zap.String("id", c.ID), zap.Int("out", k))
+		c.logger.Info("starting connection")
+		c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
 		for {
 			select {
 			case <-c.ctx.Done():
-				break
+				return
 			case informationPackage, ok := <-from.Out:
-				//c.logger.Debug("connection fo received package", zap.String("id", c.ID), zap.Int("in", k), zap.Bool("ok", ok))
+				//c.logger.Debug("connection id received package", Bool("ok", ok))
 				if !ok {
-					break
+					return
 				}
-				to[k].In <- informationPackage
-				k++
-				if k%len(to) == 0 {
-					k = 0
+				if !c.send(from, to, informationPackage) {
+					return
 				}
 			}
 		}
@@ -64,20 +255,45 @@ func (c *Connection) StreamFanOut(from *Port, to []Port) (err error) {
 	return
 }
 
+func (c *Connection) StreamFanOut(from *Port, to []Port) (err error) {
+	return c.StreamDispatch(from, to, NewRoundRobin())
+}
+
+// StreamPartitioned routes each package to the port owning its key in a
+// consistent hash ring of the to port IDs, so all the packages with the same
+// key reach the same port
+func (c *Connection) StreamPartitioned(from *Port, to []Port, key func(informationPackage *InformationPackage) string) (err error) {
+	if len(to) == 0 {
+		return errors.New("to stream a partitioned connection, at least one out port is required")
+	}
+	ids := make(map[string]bool, len(to))
+	for k := range to {
+		if ids[to[k].ID] {
+			return fmt.Errorf("to stream a partitioned connection, port IDs must be unique: %s is repeated", to[k].ID)
+		}
+		ids[to[k].ID] = true
+	}
+	return c.StreamDispatch(from, to, newPartitioner(key, to))
+}
+
 func (c *Connection) StreamFanIn(from []Port, to *Port) (err error) {
+	to.SetInputs(len(from))
 	for k, _ := range from {
 		go func(k int) {
-			c.logger.Info("starting fan in connection", zap.String("id", c.ID), zap.Int("in", k))
+			c.logger.Info("starting fan in connection", Int("in", k))
+			c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
 			for {
 				select {
 				case <-c.ctx.Done():
-					break
+					return
 				case informationPackage, ok := <-from[k].Out:
-					//c.logger.Debug("connection fi received package", zap.String("id", c.ID), zap.Int("in", k), zap.Bool("ok", ok))
+					//c.logger.Debug("connection fi received package", Int("in", k), Bool("ok", ok))
 					if !ok {
-						break
+						return
+					}
+					if !c.send(&from[k], to, informationPackage) {
+						return
 					}
-					to.In <- informationPackage
 				}
 			}
 		}(k)
@@ -91,17 +307,20 @@ func (c *Connection) StreamMulti(from []Port, to []Port) (err error) {
 	}
 	for k, _ := range from {
 		go func(k int) {
-			c.logger.Info("starting multi connection", zap.String("id", c.ID), zap.Int("in", k))
+			c.logger.Info("starting multi connection", Int("in", k))
+			c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
 			for {
 				select {
 				case <-c.ctx.Done():
-					break
+					return
 				case informationPackage, ok := <-from[k].Out:
-					//c.logger.Debug("connection multi received package", zap.String("id", c.ID), zap.Int("in", k), zap.Bool("ok", ok))
+					//c.logger.Debug("connection multi received package", Int("in", k), Bool("ok", ok))
 					if !ok {
-						break
+						return
+					}
+					if !c.send(&from[k], &to[k], informationPackage) {
+						return
 					}
-					to[k].In <- informationPackage
 				}
 			}
 		}(k)
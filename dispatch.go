@@ -0,0 +1,272 @@
+package fbp
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// errSendAborted is returned by the sends given up because the destinations
+// of the connection changed
+var errSendAborted = errors.New("send aborted")
+
+type (
+	// DispatchStrategy chooses the index of the port each package is sent to.
+	// It's only called from the connection goroutine, so it doesn't need to
+	// be safe for concurrent use
+	DispatchStrategy interface {
+		Next(informationPackage *InformationPackage, to []Port) int
+	}
+
+	roundRobin struct {
+		k int
+	}
+
+	leastLoaded struct {
+		k int
+	}
+
+	weightedRoundRobin struct {
+		weights []int
+		current []int
+	}
+
+	randomDispatch struct {
+		rnd *rand.Rand
+	}
+
+	powerOfTwoChoices struct {
+		rnd *rand.Rand
+	}
+
+	// rebalancer is implemented by the strategies that must know when the
+	// destinations of the connection change
+	rebalancer interface {
+		rebalance(to []Port)
+	}
+
+	partitioner struct {
+		ring  *HashRing
+		index map[string]int
+		key   func(informationPackage *InformationPackage) string
+	}
+)
+
+func NewRoundRobin() DispatchStrategy {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Next(informationPackage *InformationPackage, to []Port) (k int) {
+	k = rr.k % len(to)
+	rr.k = k + 1
+	return
+}
+
+// NewLeastLoaded sends each package to the port with less packages waiting
+// in its in channel. Ties are broken round robin
+func NewLeastLoaded() DispatchStrategy {
+	return &leastLoaded{}
+}
+
+func (ll *leastLoaded) Next(informationPackage *InformationPackage, to []Port) (k int) {
+	start := ll.k % len(to)
+	k = start
+	for z := 1; z < len(to); z++ {
+		candidate := (start + z) % len(to)
+		if len(to[candidate].In) < len(to[k].In) {
+			k = candidate
+		}
+	}
+	ll.k = start + 1
+	return
+}
+
+// NewWeightedRoundRobin distributes the packages proportionally to the
+// weights of the ports, interleaving them smoothly. Ports without a weight
+// get a weight of one
+func NewWeightedRoundRobin(weights ...int) DispatchStrategy {
+	return &weightedRoundRobin{
+		weights: weights,
+	}
+}
+
+func (wrr *weightedRoundRobin) Next(informationPackage *InformationPackage, to []Port) (k int) {
+	if len(wrr.current) != len(to) {
+		wrr.current = make([]int, len(to))
+	}
+	total := 0
+	for z := range to {
+		weight := 1
+		if z < len(wrr.weights) && wrr.weights[z] > 0 {
+			weight = wrr.weights[z]
+		}
+		wrr.current[z] += weight
+		total += weight
+		if wrr.current[z] > wrr.current[k] {
+			k = z
+		}
+	}
+	wrr.current[k] -= total
+	return
+}
+
+func NewRandom(seed int64) DispatchStrategy {
+	return &randomDispatch{
+		rnd: rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (rd *randomDispatch) Next(informationPackage *InformationPackage, to []Port) int {
+	return rd.rnd.Intn(len(to))
+}
+
+// NewPowerOfTwoChoices picks two ports at random and sends the package to
+// the one with less packages waiting
+func NewPowerOfTwoChoices(seed int64) DispatchStrategy {
+	return &powerOfTwoChoices{
+		rnd: rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (p2c *powerOfTwoChoices) Next(informationPackage *InformationPackage, to []Port) int {
+	a, b := p2c.rnd.Intn(len(to)), p2c.rnd.Intn(len(to))
+	if len(to[b].In) < len(to[a].In) {
+		return b
+	}
+	return a
+}
+
+func newPartitioner(key func(informationPackage *InformationPackage) string, to []Port) *partitioner {
+	p := &partitioner{
+		key: key,
+	}
+	p.rebalance(to)
+	return p
+}
+
+// rebalance builds the ring again, so only the keys of the ports added or
+// removed change of port
+func (p *partitioner) rebalance(to []Port) {
+	p.ring = NewHashRing(DefaultHashRingReplicas)
+	p.index = make(map[string]int, len(to))
+	for k := range to {
+		p.ring.Add(to[k].ID)
+		p.index[to[k].ID] = k
+	}
+}
+
+func (p *partitioner) Next(informationPackage *InformationPackage, to []Port) int {
+	return p.index[p.ring.Get(p.key(informationPackage))]
+}
+
+// StreamDispatch sends each package to one of the to ports, chosen by the
+// strategy. Checkpoint barriers are sent to all of them, before waiting for
+// any of them to be aligned. The packages are
+// numbered in the order they are read, so StreamMerge can restore it. The
+// ports can be changed with SetDestinations while streaming
+func (c *Connection) StreamDispatch(from *Port, to []Port, strategy DispatchStrategy) (err error) {
+	if len(to) == 0 {
+		return errors.New("to stream a dispatch connection, at least one out port is required")
+	}
+	c.streamDispatch(from, to, strategy)
+	return
+}
+
+func (c *Connection) streamDispatch(from *Port, to []Port, strategy DispatchStrategy) {
+	c.SetDestinations(to)
+	go func() {
+		var seq uint64
+		c.logger.Info("starting dispatch connection", Int("out", len(to)))
+		c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case informationPackage, ok := <-from.Out:
+				if !ok {
+					return
+				}
+				if !c.dispatch(from, informationPackage, strategy, &seq) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// SetDestinations replaces the ports a dispatch connection sends to. A
+// package waiting to be accepted by one of the old ports is sent to the new
+// ones instead, and it returns once that's been decided, so no package is
+// sent to the old ports afterwards. Without ports, the packages are discarded
+func (c *Connection) SetDestinations(to []Port) {
+	c.destMux.Lock()
+	c.destinations = append([]Port(nil), to...)
+	c.rebalance = true
+	old := c.generation
+	c.generation = &dispatchGeneration{changed: make(chan struct{})}
+	c.destMux.Unlock()
+
+	// The send to the old ports in progress, if any, is either accepted or
+	// aborted right away
+	if old != nil {
+		close(old.changed)
+		old.sending.Wait()
+	}
+}
+
+// sending returns the destinations to send a package to, and their
+// generation, which sending must be marked as done
+func (c *Connection) sending(strategy DispatchStrategy) (to []Port, generation *dispatchGeneration) {
+	c.destMux.Lock()
+	defer c.destMux.Unlock()
+
+	if c.generation == nil {
+		c.generation = &dispatchGeneration{changed: make(chan struct{})}
+	}
+	if c.rebalance {
+		c.rebalance = false
+		if r, ok := strategy.(rebalancer); ok {
+			r.rebalance(c.destinations)
+		}
+	}
+	c.generation.sending.Add(1)
+	return c.destinations, c.generation
+}
+
+// dispatch sends a package to the destination chosen by the strategy. When
+// the destinations change before it's accepted, it's dispatched again among
+// the new ones. A barrier is sent once to each of the destinations. The
+// packages numbered by a dispatch upstream keep their number, so the merges
+// downstream follow the order they entered the graph in
+func (c *Connection) dispatch(from *Port, informationPackage *InformationPackage, strategy DispatchStrategy, seq *uint64) bool {
+	if !informationPackage.IsBarrier() && informationPackage.Seq == 0 {
+		*seq++
+		informationPackage.Seq = *seq
+	}
+	var reached map[chan *InformationPackage]bool
+	for {
+		to, generation := c.sending(strategy)
+		var err error
+		switch {
+		case len(to) == 0:
+		case informationPackage.IsBarrier():
+			if reached == nil {
+				reached = make(map[chan *InformationPackage]bool, len(to))
+			}
+			for z := 0; z < len(to) && err == nil; z++ {
+				if reached[to[z].In] {
+					continue
+				}
+				if err = c.sendUntil(from, &to[z], informationPackage, generation.changed); err == nil {
+					reached[to[z].In] = true
+				}
+			}
+		default:
+			err = c.sendUntil(from, &to[strategy.Next(informationPackage, to)], informationPackage, generation.changed)
+		}
+		generation.sending.Done()
+
+		if err != errSendAborted {
+			return err == nil
+		}
+	}
+}
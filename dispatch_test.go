@@ -0,0 +1,161 @@
+package fbp_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+/*
+	These benchmarks measure the throughput of the fan out dispatch
+	strategies when one of the workers is much slower than the others
+
+	              / ---> worker (slow) >---\
+	dispatcher >  ----> worker >----------  > sink
+	              \ ---> worker >----------/
+*/
+
+const (
+	benchmarkChannelSz = 4
+	benchmarkWorkers   = 4
+	benchmarkLatency   = 200 * time.Microsecond
+)
+
+type sleeperTask struct {
+	latency time.Duration
+}
+
+func (st *sleeperTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	time.Sleep(st.latency)
+	return in, nil
+}
+
+func benchmarkPorts(sz int, id string) (ports []fbp.Port) {
+	ports = make([]fbp.Port, sz)
+	for c := 0; c < sz; c++ {
+		ports[c] = *fbp.NewPort(
+			id+"_"+fmt.Sprint(c),
+			make(chan *fbp.InformationPackage, benchmarkChannelSz),
+			make(chan *fbp.InformationPackage, benchmarkChannelSz),
+		)
+	}
+	return
+}
+
+func benchmarkDispatch(b *testing.B, strategy fbp.DispatchStrategy) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := fbp.NewNopLogger()
+	errorHandler := fbp.NewErrorHandler(logger)
+	dispatcherPort := benchmarkPorts(1, "dispatcher")
+	workerPorts := benchmarkPorts(benchmarkWorkers, "worker")
+	sinkPort := benchmarkPorts(1, "sink")
+
+	// The first worker is ten times slower than the rest
+	for k := range workerPorts {
+		latency := benchmarkLatency
+		if k == 0 {
+			latency *= 10
+		}
+		fbp.NewComponent(ctx, workerPorts[k].ID, &workerPorts[k], &sleeperTask{latency: latency}, errorHandler, logger).Stream()
+	}
+
+	if err := fbp.NewConnection(ctx, "dispatch", logger).StreamDispatch(&dispatcherPort[0], workerPorts, strategy); err != nil {
+		b.Fatal(err)
+	}
+	if err := fbp.NewConnection(ctx, "collect", logger).StreamFanIn(workerPorts, &sinkPort[0]); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	go func() {
+		for z := 0; z < b.N; z++ {
+			select {
+			case <-ctx.Done():
+				return
+			case dispatcherPort[0].Out <- fbp.NewInformationPackage(fmt.Sprintf("package_%d", z), nil):
+			}
+		}
+	}()
+	for z := 0; z < b.N; z++ {
+		<-sinkPort[0].In
+	}
+}
+
+func BenchmarkDispatchRoundRobin(b *testing.B) {
+	benchmarkDispatch(b, fbp.NewRoundRobin())
+}
+
+func BenchmarkDispatchWeightedRoundRobin(b *testing.B) {
+	benchmarkDispatch(b, fbp.NewWeightedRoundRobin(1, 10, 10, 10))
+}
+
+func BenchmarkDispatchRandom(b *testing.B) {
+	benchmarkDispatch(b, fbp.NewRandom(1))
+}
+
+func BenchmarkDispatchLeastLoaded(b *testing.B) {
+	benchmarkDispatch(b, fbp.NewLeastLoaded())
+}
+
+func BenchmarkDispatchPowerOfTwoChoices(b *testing.B) {
+	benchmarkDispatch(b, fbp.NewPowerOfTwoChoices(1))
+}
+
+// TestOrderedMergeAcrossDispatches dispatches the packages twice, with a plain
+// fan in between that mixes up their order, and checks the ordered merge at
+// the end still follows the order of the first dispatch
+func TestOrderedMergeAcrossDispatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const packages = 50
+	logger := fbp.NewNopLogger()
+	errorHandler := fbp.NewErrorHandler(logger)
+	sourcePort := benchmarkPorts(1, "source")
+	firstPorts := benchmarkPorts(3, "first")
+	fanInPort := benchmarkPorts(1, "fan_in")
+	secondPorts := benchmarkPorts(2, "second")
+	sinkPort := benchmarkPorts(1, "sink")
+
+	// The first worker is slower than the rest, so the fan in mixes up the order
+	for _, ports := range [][]fbp.Port{firstPorts, fanInPort, secondPorts} {
+		for k := range ports {
+			var latency time.Duration
+			if &ports[k] == &firstPorts[0] {
+				latency = benchmarkLatency
+			}
+			fbp.NewComponent(ctx, ports[k].ID, &ports[k], &sleeperTask{latency: latency}, errorHandler, logger).Stream()
+		}
+	}
+
+	fbp.NewConnection(ctx, "first", logger).StreamDispatch(&sourcePort[0], firstPorts, fbp.NewRoundRobin())
+	fbp.NewConnection(ctx, "fan_in", logger).StreamFanIn(firstPorts, &fanInPort[0])
+	fbp.NewConnection(ctx, "second", logger).StreamDispatch(&fanInPort[0], secondPorts, fbp.NewRoundRobin())
+	fbp.NewConnection(ctx, "merge", logger).StreamMerge(secondPorts, &sinkPort[0], fbp.NewOrderedMerge(packages))
+
+	go func() {
+		for z := 0; z < packages; z++ {
+			select {
+			case <-ctx.Done():
+				return
+			case sourcePort[0].Out <- fbp.NewInformationPackage(fmt.Sprintf("package_%d", z), nil):
+			}
+		}
+	}()
+	timeout := time.After(5 * time.Second)
+	for z := 0; z < packages; z++ {
+		select {
+		case ip := <-sinkPort[0].In:
+			if want := fmt.Sprintf("package_%d", z); ip.ID != want {
+				t.Fatalf("got %s, want %s", ip.ID, want)
+			}
+		case <-timeout:
+			t.Fatalf("received %d packages, want %d", z, packages)
+		}
+	}
+}
@@ -1,19 +1,33 @@
 package fbp
 
-import (
-	"go.uber.org/zap"
-)
-
-func NewErrorHandler(logger *zap.Logger) *ErrorHandler {
+func NewErrorHandler(logger Logger) *ErrorHandler {
 	return &ErrorHandler{
 		logger: logger,
 	}
 }
 
+// NewErrorHandlerFunc returns an error handler that calls f with each error,
+// besides logging it
+func NewErrorHandlerFunc(logger Logger, f func(err error)) *ErrorHandler {
+	return &ErrorHandler{
+		logger:  logger,
+		onError: f,
+	}
+}
+
 type ErrorHandler struct {
-	logger *zap.Logger
+	logger  Logger
+	onError func(err error)
 }
 
 func (eh ErrorHandler) Handle(err error) {
-	eh.logger.Error(err.Error())
+	eh.handle(err)
+}
+
+// handle logs the error with the fields of where it happened
+func (eh ErrorHandler) handle(err error, fields ...Field) {
+	eh.logger.Error(err.Error(), fields...)
+	if eh.onError != nil {
+		eh.onError(err)
+	}
 }
@@ -0,0 +1,143 @@
+package fbp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ComponentStarted EventKind = iota
+	ComponentStopped
+	// ComponentRestarted is published when a component recovers from a task panic
+	ComponentRestarted
+	PortClosed
+	ConnectionStarted
+	PackageDropped
+	ErrorRaised
+	// BackpressureEngaged is published when a connection finds the in channel
+	// of its destination full, and BackpressureReleased when it gets through
+	BackpressureEngaged
+	BackpressureReleased
+	CircuitStateChanged
+	NetworkStarted
+	NetworkStopped
+	// ComponentScaled is published when an autoscaler changes the concurrency
+	// of a component
+	ComponentScaled
+)
+
+var eventKinds = map[EventKind]string{
+	ComponentStarted:     "component started",
+	ComponentStopped:     "component stopped",
+	ComponentRestarted:   "component restarted",
+	PortClosed:           "port closed",
+	ConnectionStarted:    "connection started",
+	PackageDropped:       "package dropped",
+	ErrorRaised:          "error",
+	BackpressureEngaged:  "backpressure engaged",
+	BackpressureReleased: "backpressure released",
+	CircuitStateChanged:  "circuit state changed",
+	NetworkStarted:       "network started",
+	NetworkStopped:       "network stopped",
+	ComponentScaled:      "component scaled",
+}
+
+type (
+	EventKind int
+
+	// Event is something that happened to a component, a connection or a
+	// network, identified by Source. The rest of the fields are set when they
+	// apply to the kind of event
+	Event struct {
+		Kind      EventKind
+		Time      time.Time
+		Source    string
+		Port      string
+		PackageID string
+		Err       error
+		Detail    string
+	}
+
+	// EventBus delivers the published events to its subscribers. It never
+	// blocks the publishers: a subscriber that doesn't keep up misses events
+	EventBus struct {
+		mux         sync.RWMutex
+		clock       Clock
+		next        int
+		subscribers map[int]chan Event
+	}
+
+	// EventAware is implemented by the tasks that publish events. A network
+	// gives them its bus
+	EventAware interface {
+		SetEventBus(bus *EventBus)
+	}
+)
+
+func (k EventKind) String() string {
+	if s, ok := eventKinds[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		clock:       RealClock{},
+		subscribers: make(map[int]chan Event),
+	}
+}
+
+// SetClock sets the clock the events are timestamped with
+func (eb *EventBus) SetClock(clock Clock) {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+
+	eb.clock = clock
+}
+
+// Subscribe returns a channel receiving the events published from now on,
+// buffering up to buffer of them, and the function to unsubscribe, which
+// closes the channel
+func (eb *EventBus) Subscribe(buffer int) (events <-chan Event, unsubscribe func()) {
+	eb.mux.Lock()
+	defer eb.mux.Unlock()
+
+	id := eb.next
+	eb.next++
+	c := make(chan Event, buffer)
+	eb.subscribers[id] = c
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			eb.mux.Lock()
+			defer eb.mux.Unlock()
+
+			delete(eb.subscribers, id)
+			close(c)
+		})
+	}
+}
+
+// Publish sends the event to the subscribers with room for it. Publishing
+// to a nil bus does nothing, so the publishers don't need to check for it
+func (eb *EventBus) Publish(event Event) {
+	if eb == nil {
+		return
+	}
+
+	eb.mux.RLock()
+	defer eb.mux.RUnlock()
+
+	if event.Time.IsZero() {
+		event.Time = eb.clock.Now()
+	}
+	for _, c := range eb.subscribers {
+		select {
+		case c <- event:
+		default:
+		}
+	}
+}
@@ -17,7 +17,11 @@ import (
 /*
 	This is a very simple implementation of map/reduce paradigm using github.com/theskyinflames/fbp library
 
-	-->mapper-->reducer-->writer
+	-->mapper-->window-->writer
+
+	The window reduces the amounts of the last day, by the hour. It's an event
+	time sliding window, so the data older than a day is left out without
+	hand rolling the time filter.
 
 	This version doesn't implement parallelism
 */
@@ -41,11 +45,8 @@ var (
 
 	reduceFunc func(map[time.Time]int, int) int = func(in map[time.Time]int, accAmount int) (out int) {
 		out = accAmount
-		now := time.Now()
-		for k, v := range in {
-			if now.Sub(k).Hours() <= 24 {
-				out += v
-			}
+		for _, v := range in {
+			out += v
 		}
 		return
 	}
@@ -63,12 +64,6 @@ type (
 		mapFunc func([]Data) map[time.Time]int
 	}
 
-	reducerTask struct {
-		id          string
-		totalAmount int
-		reduceFunc  func(map[time.Time]int, int) int
-	}
-
 	writerTask struct {
 		id     string
 		writer io.Writer
@@ -86,26 +81,48 @@ func (mt *mapperTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackag
 	data, _ := in.Status.Iterator()()
 	mapped := mt.mapFunc(data.(tData))
 	out = &fbp.InformationPackage{
-		ID:     mt.id,
+		ID:     in.ID,
 		Status: &set.Set{},
 	}
 	out.Status.Add(func() string { return mt.id }, mapped)
 	return
 }
 
-func (rt *reducerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
-	toReduce, _ := in.Status.Iterator()()
+func mapped(ip *fbp.InformationPackage) map[time.Time]int {
+	item, _ := ip.Status.Iterator()()
+	return item.(map[time.Time]int)
+}
 
-	reduced := rt.reduceFunc(toReduce.(map[time.Time]int), rt.totalAmount)
-	out = &fbp.InformationPackage{
-		ID:     rt.id,
-		Status: &set.Set{},
+// eventTime is the time of the data mapped from a package, which is a single one
+func eventTime(ip *fbp.InformationPackage) (t time.Time) {
+	for ts := range mapped(ip) {
+		if ts.After(t) {
+			t = ts
+		}
 	}
-	out.Status.Add(func() string { return Reduced + "_" + rt.id }, reduced)
-
 	return
 }
 
+// reducer reduces the packages of the window that ends within the next slide,
+// which is the last day, and emits nothing for the rest of windows
+func reducer(now time.Time, slide time.Duration) fbp.Aggregator {
+	return func(bounds fbp.WindowBounds, packages []*fbp.InformationPackage) *fbp.InformationPackage {
+		if !bounds.End.After(now) || bounds.End.After(now.Add(slide)) {
+			return nil
+		}
+		var reduced int
+		for _, ip := range packages {
+			reduced = reduceFunc(mapped(ip), reduced)
+		}
+		out := &fbp.InformationPackage{
+			ID:     "reducer",
+			Status: &set.Set{},
+		}
+		out.Status.Add(func() string { return Reduced }, reduced)
+		return out
+	}
+}
+
 func (wt *writerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
 
 	item, _ := in.Status.Iterator()()
@@ -138,22 +155,23 @@ func main() {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	_ = cancel
-
-	logger := zap.NewExample()
+	logger := fbp.NewZapLogger(zap.NewExample())
 	errorHander := fbp.NewErrorHandler(logger)
+	clock := fbp.RealClock{}
+	now := clock.Now()
 
-	// Define ports
-	reducerPort := fbp.NewPort(
-		"reducerInPort",
-		make(chan *fbp.InformationPackage, channelSz),
-		make(chan *fbp.InformationPackage, channelSz),
-	)
+	// Define ports. The window reads what the mapper sends, so it emits its
+	// last windows when the mapper is drained
 	mapperPort := fbp.NewPort(
 		"mapperInPort",
 		make(chan *fbp.InformationPackage, channelSz),
 		make(chan *fbp.InformationPackage, channelSz),
 	)
+	windowPort := fbp.NewPort(
+		"windowInPort",
+		mapperPort.Out,
+		make(chan *fbp.InformationPackage, channelSz),
+	)
 	writerPort := fbp.NewPort(
 		"writerInPort",
 		make(chan *fbp.InformationPackage, channelSz),
@@ -161,14 +179,9 @@ func main() {
 	)
 
 	// Define connections
-	fromMapperToReducerConnection := fbp.NewConnection(
+	fromWindowToWriterConnection := fbp.NewConnection(
 		ctx,
-		"fromMapperToReducerConnection",
-		logger,
-	)
-	fromReducerToWriterConnection := fbp.NewConnection(
-		ctx,
-		"fromReducerToWriterConnection",
+		"fromWindowToWriterConnection",
 		logger,
 	)
 
@@ -184,17 +197,27 @@ func main() {
 		errorHander,
 		logger,
 	)
-	reducerComponent := fbp.NewComponent(
+	window, err := fbp.NewWindow(
 		ctx,
-		"reducer",
-		reducerPort,
-		&reducerTask{
-			id:         "reducer",
-			reduceFunc: reduceFunc,
+		"window",
+		windowPort,
+		fbp.WindowSpec{
+			Kind:      fbp.SlidingWindow,
+			Domain:    fbp.EventTime,
+			Size:      24 * time.Hour,
+			Slide:     time.Hour,
+			EventTime: eventTime,
+			// The data is not sorted by time
+			AllowedLateness: 48 * time.Hour,
 		},
+		reducer(now, time.Hour),
 		errorHander,
 		logger,
 	)
+	if err != nil {
+		logger.Error("creating window", fbp.Err(err))
+		os.Exit(1)
+	}
 	writerComponent := fbp.NewComponent(
 		ctx,
 		"writer",
@@ -209,12 +232,11 @@ func main() {
 
 	// Start the components
 	mapperComponent.Stream()
-	reducerComponent.Stream()
+	window.Stream()
 	writerComponent.Stream()
 
 	// Start the connections
-	fromMapperToReducerConnection.StreamSingle(mapperPort, reducerPort)
-	fromReducerToWriterConnection.StreamSingle(reducerPort, writerPort)
+	fromWindowToWriterConnection.StreamSingle(windowPort, writerPort)
 
 	// At this point, all the components are wainting for ready data
 	// from its in ports, use it to execute its tasks, and write the
@@ -224,20 +246,26 @@ func main() {
 	data := tData{
 		Data{
 			Amount:    1,
-			Timestamp: time.Now(),
+			Timestamp: now,
 		},
 		Data{
 			Amount:    2,
-			Timestamp: time.Now().Add(-1 * time.Hour),
+			Timestamp: now.Add(-1 * time.Hour),
 		},
 		Data{
 			Amount:    3,
-			Timestamp: time.Now().Add(-25 * time.Hour),
+			Timestamp: now.Add(-25 * time.Hour),
 		},
 	}
 
-	// Send the data to be processed
-	mapperPort.In <- fbp.NewInformationPackage("ip1", data)
+	// Send the data to be processed, each one in its own package, so the
+	// window can tell their times apart
+	for k, d := range data {
+		mapperPort.In <- fbp.NewInformationPackage(fmt.Sprintf("ip%d", k+1), tData{d})
+	}
+	if err := mapperComponent.Drain(ctx); err != nil {
+		logger.Error("draining the mapper", fbp.Err(err))
+	}
 
 	// Wait for a the process ends
 	time.Sleep(1 * time.Second)
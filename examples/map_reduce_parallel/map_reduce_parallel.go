@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -21,6 +22,12 @@ import (
 	         / ----->mapper >--> reducer >------\
 	reader > ------->mapper >--> reducer >-------  > writer
 		     \------>mapper >--> reducer >------/
+
+	Halfway through, three more mappers are started, each one connected to a
+	reducer, and the reader starts sending to the six of them without stopping.
+
+	The accumulated amount is checkpointed when all the packages have been
+	sent, in a temporary directory that is removed at the end
 */
 
 func (d Data) Key() func() string {
@@ -46,8 +53,7 @@ var (
 		return
 	}
 
-	reduceFunc func(map[time.Time]int, *int32) int32 = func(in map[time.Time]int, accAmount *int32) (out int32) {
-		now := time.Now()
+	reduceFunc func(map[time.Time]int, *int32, time.Time) int32 = func(in map[time.Time]int, accAmount *int32, now time.Time) (out int32) {
 		for k, v := range in {
 			if now.Sub(k).Hours() <= 24 {
 				out = atomic.AddInt32(accAmount, int32(v))
@@ -76,7 +82,8 @@ type (
 	reducerTask struct {
 		id         string
 		counter    *int32
-		reduceFunc func(map[time.Time]int, *int32) int32
+		reduceFunc func(map[time.Time]int, *int32, time.Time) int32
+		clock      fbp.Clock
 	}
 
 	writerTask struct {
@@ -121,7 +128,7 @@ func (mt *mapperTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackag
 func (rt *reducerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
 	toReduce, _ := in.Status.Iterator()()
 
-	reduced := rt.reduceFunc(toReduce.(map[time.Time]int), rt.counter)
+	reduced := rt.reduceFunc(toReduce.(map[time.Time]int), rt.counter, rt.clock.Now())
 	out = &fbp.InformationPackage{
 		ID:     rt.id,
 		Status: &set.Set{},
@@ -130,6 +137,21 @@ func (rt *reducerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPacka
 	return
 }
 
+func (rt *reducerTask) SetClock(clock fbp.Clock) {
+	rt.clock = clock
+}
+
+func (rt *reducerTask) Snapshot() (state []byte, err error) {
+	state = make([]byte, 4)
+	binary.BigEndian.PutUint32(state, uint32(atomic.LoadInt32(rt.counter)))
+	return
+}
+
+func (rt *reducerTask) Restore(state []byte) (err error) {
+	atomic.StoreInt32(rt.counter, int32(binary.BigEndian.Uint32(state)))
+	return
+}
+
 func (wt *writerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
 
 	item, _ := in.Status.Iterator()()
@@ -150,7 +172,7 @@ func addToOut(ts time.Time, amount int, m map[time.Time]int) {
 	return
 }
 
-func startReaderComponent(ctx context.Context, readerPort *fbp.Port, errorHander *fbp.ErrorHandler, logger *zap.Logger) {
+func startReaderComponent(ctx context.Context, readerPort *fbp.Port, errorHander *fbp.ErrorHandler, logger fbp.Logger) {
 	readerComponent := fbp.NewComponent(
 		ctx,
 		"reader",
@@ -164,9 +186,9 @@ func startReaderComponent(ctx context.Context, readerPort *fbp.Port, errorHander
 	readerComponent.Stream()
 }
 
-func startMapperComponents(ctx context.Context, mapperPorts []fbp.Port, errorHander *fbp.ErrorHandler, logger *zap.Logger) {
+func startMapperComponents(ctx context.Context, first int, mapperPorts []fbp.Port, errorHander *fbp.ErrorHandler, logger fbp.Logger) {
 	fid := func(k int) string {
-		return fmt.Sprintf("mapper_%d", k)
+		return fmt.Sprintf("mapper_%d", first+k)
 	}
 	for k, _ := range mapperPorts {
 		mapperComponent := fbp.NewComponent(
@@ -184,13 +206,14 @@ func startMapperComponents(ctx context.Context, mapperPorts []fbp.Port, errorHan
 	}
 }
 
-func startReducerComponents(ctx context.Context, reducerPorts []fbp.Port, errorHander *fbp.ErrorHandler, logger *zap.Logger) {
+func startReducerComponents(ctx context.Context, reducerPorts []fbp.Port, checkpointer *fbp.Checkpointer, clock fbp.Clock, errorHander *fbp.ErrorHandler, logger fbp.Logger) {
 	fid := func(k int) string {
 		return fmt.Sprintf("reducer_%d", k)
 	}
 	accumulator := int32(0)
+	reducerComponents := make([]*fbp.Component, len(reducerPorts))
 	for k, _ := range reducerPorts {
-		reducerComponent := fbp.NewComponent(
+		reducerComponents[k] = fbp.NewComponent(
 			ctx,
 			fid(k),
 			&reducerPorts[k],
@@ -198,15 +221,19 @@ func startReducerComponents(ctx context.Context, reducerPorts []fbp.Port, errorH
 				id:         fid(k),
 				counter:    &accumulator,
 				reduceFunc: reduceFunc,
+				clock:      clock,
 			},
 			errorHander,
 			logger,
 		)
+		reducerComponents[k].SetCheckpointer(checkpointer)
+	}
+	for _, reducerComponent := range reducerComponents {
 		reducerComponent.Stream()
 	}
 }
 
-func startWriterComponent(ctx context.Context, writerPort *fbp.Port, errorHander *fbp.ErrorHandler, logger *zap.Logger) {
+func startWriterComponent(ctx context.Context, writerPort *fbp.Port, errorHander *fbp.ErrorHandler, logger fbp.Logger) {
 	writer := fbp.NewComponent(
 		ctx,
 		"mapperComponent",
@@ -221,11 +248,11 @@ func startWriterComponent(ctx context.Context, writerPort *fbp.Port, errorHander
 	writer.Stream()
 }
 
-func getPortSlice(sz int, id string) (ports []fbp.Port) {
+func getPortSlice(first int, sz int, id string) (ports []fbp.Port) {
 	ports = make([]fbp.Port, sz)
 	for c := 0; c < sz; c++ {
 		ports[c] = *fbp.NewPort(
-			id+"_"+fmt.Sprint(c),
+			id+"_"+fmt.Sprint(first+c),
 			make(chan *fbp.InformationPackage, channelSz),
 			make(chan *fbp.InformationPackage, channelSz),
 		)
@@ -233,16 +260,16 @@ func getPortSlice(sz int, id string) (ports []fbp.Port) {
 	return
 }
 
-func startConnectionsFromReaderToMapper(ctx context.Context, inPort *fbp.Port, outPorts []fbp.Port, logger *zap.Logger) (connections []fbp.Connection, err error) {
-	conn := fbp.NewConnection(
+func startConnectionsFromReaderToMapper(ctx context.Context, inPort *fbp.Port, outPorts []fbp.Port, logger fbp.Logger) (conn *fbp.Connection, err error) {
+	conn = fbp.NewConnection(
 		ctx,
 		"fromReaderToMapper",
 		logger,
 	)
-	conn.StreamFanOut(inPort, outPorts)
+	err = conn.StreamFanOut(inPort, outPorts)
 	return
 }
-func startConnectionsFromMapperToReducer(ctx context.Context, inPorts []fbp.Port, outPorts []fbp.Port, logger *zap.Logger) (connections []fbp.Connection, err error) {
+func startConnectionsFromMapperToReducer(ctx context.Context, inPorts []fbp.Port, outPorts []fbp.Port, logger fbp.Logger) (connections []fbp.Connection, err error) {
 	conn := fbp.NewConnection(
 		ctx,
 		"fromMapperToReducer",
@@ -251,7 +278,7 @@ func startConnectionsFromMapperToReducer(ctx context.Context, inPorts []fbp.Port
 	conn.StreamMulti(inPorts, outPorts)
 	return
 }
-func startConnectionsFromReducerToWriter(ctx context.Context, inPort []fbp.Port, outPort *fbp.Port, logger *zap.Logger) (connections []fbp.Connection, err error) {
+func startConnectionsFromReducerToWriter(ctx context.Context, inPort []fbp.Port, outPort *fbp.Port, logger fbp.Logger) (connections []fbp.Connection, err error) {
 	conn := fbp.NewConnection(
 		ctx,
 		"fromReducerToWriter",
@@ -261,29 +288,76 @@ func startConnectionsFromReducerToWriter(ctx context.Context, inPort []fbp.Port,
 	return
 }
 
+// scaleMappers starts sz mappers more, each one sending to a reducer, and
+// then makes the reader connection send to all the mappers
+func scaleMappers(ctx context.Context, readerConn *fbp.Connection, mapperPorts []fbp.Port, reducerPorts []fbp.Port, sz int, errorHander *fbp.ErrorHandler, logger fbp.Logger) []fbp.Port {
+	added := getPortSlice(len(mapperPorts), sz, "mapperPort")
+	startMapperComponents(ctx, len(mapperPorts), added, errorHander, logger)
+
+	// The reducers get more inputs, so the checkpoint barriers must be aligned.
+	// No checkpoint is in progress, so they can be changed while streaming
+	inputs := make([]int, len(reducerPorts))
+	for k := range mapperPorts {
+		inputs[k%len(reducerPorts)]++
+	}
+	for k := range added {
+		inputs[(len(mapperPorts)+k)%len(reducerPorts)]++
+	}
+	for k := range reducerPorts {
+		reducerPorts[k].SetInputs(inputs[k])
+	}
+	for k := range added {
+		conn := fbp.NewConnection(ctx, fmt.Sprintf("fromMapperToReducer_%d", len(mapperPorts)+k), logger)
+		conn.StreamSingle(&added[k], &reducerPorts[(len(mapperPorts)+k)%len(reducerPorts)])
+	}
+
+	mapperPorts = append(mapperPorts[:len(mapperPorts):len(mapperPorts)], added...)
+	readerConn.SetDestinations(mapperPorts)
+	logger.Info("mappers scaled", fbp.Int("mappers", len(mapperPorts)))
+	return mapperPorts
+}
+
 func main() {
 
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	logger := zap.NewExample()
+	logger := fbp.NewZapLogger(zap.NewExample())
 	errorHander := fbp.NewErrorHandler(logger)
+	clock := fbp.RealClock{}
+
+	checkpointDir, err := os.MkdirTemp("", "fbp_map_reduce_parallel")
+	if err != nil {
+		logger.Error("creating checkpoint dir", fbp.Err(err))
+		os.Exit(1)
+	}
+	checkpointer, err := fbp.NewCheckpointer(checkpointDir, logger)
+	if err != nil {
+		os.RemoveAll(checkpointDir)
+		logger.Error("creating checkpointer", fbp.Err(err))
+		os.Exit(1)
+	}
 
 	// Define ports
-	readerPort := getPortSlice(1, "readerPort")
-	reducerPorts := getPortSlice(3, "reducerPort")
-	mapperPorts := getPortSlice(3, "mapperPort")
-	writerPort := getPortSlice(1, "writerPort")
+	readerPort := getPortSlice(0, 1, "readerPort")
+	reducerPorts := getPortSlice(0, 3, "reducerPort")
+	mapperPorts := getPortSlice(0, 3, "mapperPort")
+	writerPort := getPortSlice(0, 1, "writerPort")
 
 	// Start components
 	startReaderComponent(ctx, &readerPort[0], errorHander, logger)
-	startMapperComponents(ctx, mapperPorts, errorHander, logger)
-	startReducerComponents(ctx, reducerPorts, errorHander, logger)
+	startMapperComponents(ctx, 0, mapperPorts, errorHander, logger)
+	startReducerComponents(ctx, reducerPorts, checkpointer, clock, errorHander, logger)
 	startWriterComponent(ctx, &writerPort[0], errorHander, logger)
 
 	// Start the connections
-	startConnectionsFromReaderToMapper(ctx, &readerPort[0], mapperPorts, logger)
+	readerConn, err := startConnectionsFromReaderToMapper(ctx, &readerPort[0], mapperPorts, logger)
+	if err != nil {
+		logger.Error("connecting the reader", fbp.Err(err))
+		os.RemoveAll(checkpointDir)
+		os.Exit(1)
+	}
 	startConnectionsFromMapperToReducer(ctx, mapperPorts, reducerPorts, logger)
 	startConnectionsFromReducerToWriter(ctx, reducerPorts, &writerPort[0], logger)
 
@@ -292,29 +366,41 @@ func main() {
 	// resulting output by its out ports
 
 	// Prepare the data to be processed
+	now := clock.Now()
 	data := tData{
 		Data{
 			Amount:    1,
-			Timestamp: time.Now(),
+			Timestamp: now,
 		},
 		Data{
 			Amount:    2,
-			Timestamp: time.Now().Add(-1 * time.Hour),
+			Timestamp: now.Add(-1 * time.Hour),
 		},
 		Data{
 			Amount:    3,
-			Timestamp: time.Now().Add(-25 * time.Hour),
+			Timestamp: now.Add(-25 * time.Hour),
 		},
 	}
 
 	// Send the data packages to be processed
 	for z := 0; z < 200; z++ {
+		if z == 100 {
+			mapperPorts = scaleMappers(ctx, readerConn, mapperPorts, reducerPorts, 3, errorHander, logger)
+		}
 		readerPort[0].In <- fbp.NewInformationPackage(fmt.Sprintf("package_%d", z), data)
 	}
 
+	// Checkpoint the accumulated amount once all the packages are reduced
+	checkpointCtx, checkpointCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer checkpointCancel()
+	if _, err := checkpointer.Trigger(checkpointCtx, &readerPort[0]); err != nil {
+		logger.Error("checkpointing", fbp.Err(err))
+	}
+
 	// Wait for a the process ends
 	fmt.Println("waiting for components an connections ends ...")
 	time.Sleep(1 * time.Second)
 
+	os.RemoveAll(checkpointDir)
 	os.Exit(0)
 }
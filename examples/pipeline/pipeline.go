@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+/*
+	This example builds a minimal pipeline with the option based constructors,
+	which create the ports and default the logger and the error handler
+
+	squarer >--> printer
+
+	The squarer processes four packages at the same time, so they are printed
+	in any order
+*/
+
+type number int
+
+func (n number) Key() func() string {
+	return func() string {
+		return "number"
+	}
+}
+
+func value(ip *fbp.InformationPackage) number {
+	item, _ := ip.Status.Iterator()()
+	return item.(number)
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	squarer := fbp.NewComponentWith(ctx, "squarer",
+		fbp.ContextTaskFunc(func(ctx context.Context, in *fbp.InformationPackage) (*fbp.InformationPackage, error) {
+			n := value(in)
+			return fbp.NewInformationPackage(in.ID, n*n), nil
+		}),
+		fbp.WithConcurrency(4),
+		fbp.WithTimeout(time.Second),
+	)
+	printer := fbp.NewComponentWith(ctx, "printer",
+		fbp.ContextTaskFunc(func(ctx context.Context, in *fbp.InformationPackage) (*fbp.InformationPackage, error) {
+			fmt.Printf("printer, package: %s, data: %d\n", in.ID, value(in))
+			wg.Done()
+			return nil, nil
+		}),
+	)
+	squarer.Stream()
+	printer.Stream()
+	fbp.NewConnectionWith(ctx, "squarer_to_printer").StreamSingle(squarer.Port(), printer.Port())
+
+	for k := 1; k <= 10; k++ {
+		wg.Add(1)
+		squarer.Port().In <- fbp.NewInformationPackage(fmt.Sprintf("ip%d", k), number(k))
+	}
+	wg.Wait()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/theskyinflames/fbp"
+	"github.com/theskyinflames/set"
+)
+
+/*
+	This example splits a graph in two halves connected over TCP. Both halves
+	run in the same process over loopback, but each of them could live in its
+	own process, on the same host or across the LAN
+
+	producer >--tcp--> consumer
+*/
+
+const (
+	channelSz = 10
+	window    = 5
+)
+
+type (
+	Amount int
+
+	doublerTask struct {
+		id string
+	}
+
+	writerTask struct {
+		id     string
+		writer io.Writer
+	}
+)
+
+func (a Amount) Key() func() string {
+	return func() string {
+		return fmt.Sprintf("amount_%d", a)
+	}
+}
+
+func (dt *doublerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	item, _ := in.Status.Iterator()()
+	out = &fbp.InformationPackage{
+		ID:     in.ID,
+		Status: &set.Set{},
+	}
+	out.Status.Add(func() string { return dt.id }, item.(Amount)*2)
+	return
+}
+
+func (wt *writerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	item, _ := in.Status.Iterator()()
+	wt.writer.Write([]byte(fmt.Sprintf("writer id:%s, package: %s, amount: %d\n", wt.id, in.ID, item.(Amount))))
+	return
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := fbp.NewZapLogger(zap.NewExample())
+	errorHandler := fbp.NewErrorHandler(logger)
+
+	// The concrete types stored in the packages status must be known by gob
+	gob.Register(Amount(0))
+	codec := fbp.NewGobCodec()
+
+	// Consumer side: listen for packages and write them
+	consumerPort := fbp.NewPort(
+		"consumerPort",
+		make(chan *fbp.InformationPackage, channelSz),
+		make(chan *fbp.InformationPackage, channelSz),
+	)
+	fbp.NewComponent(ctx, "consumer", consumerPort, &writerTask{id: "consumer", writer: os.Stdout}, errorHandler, logger).Stream()
+	addr, err := fbp.NewConnection(ctx, "fromNetworkToConsumer", logger).ListenRemote("127.0.0.1:0", consumerPort, codec, window)
+	if err != nil {
+		logger.Error("listening", fbp.Err(err))
+		os.Exit(1)
+	}
+
+	// Producer side: double the amounts and send them to the consumer
+	producerPort := fbp.NewPort(
+		"producerPort",
+		make(chan *fbp.InformationPackage, channelSz),
+		make(chan *fbp.InformationPackage, channelSz),
+	)
+	fbp.NewComponent(ctx, "producer", producerPort, &doublerTask{id: "producer"}, errorHandler, logger).Stream()
+	fbp.NewConnection(ctx, "fromProducerToNetwork", logger).StreamRemote(producerPort, addr.String(), codec)
+
+	for z := 0; z < 20; z++ {
+		producerPort.In <- fbp.NewInformationPackage(fmt.Sprintf("package_%d", z), Amount(z))
+	}
+
+	// Wait for a the process ends
+	time.Sleep(1 * time.Second)
+
+	os.Exit(0)
+}
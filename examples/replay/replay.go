@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+	"go.uber.org/zap"
+)
+
+/*
+	This example records the packages crossing a connection, and replays the
+	recording into another printer ten times faster
+
+	producer >--> printer      (recorded)
+	replay   >--> printer
+
+	The producer sends a package every 100ms, so the replay sends one every 10ms
+*/
+
+type number int
+
+func (n number) Key() func() string {
+	return func() string {
+		return "number"
+	}
+}
+
+func printer(ctx context.Context, name string, wg *sync.WaitGroup) *fbp.Component {
+	start := time.Now()
+	return fbp.NewComponentWith(ctx, name,
+		fbp.ContextTaskFunc(func(ctx context.Context, in *fbp.InformationPackage) (*fbp.InformationPackage, error) {
+			item, _ := in.Status.Iterator()()
+			fmt.Printf("%s, after %v, package: %s, data: %v, headers: %v\n", name, time.Since(start).Round(time.Millisecond), in.ID, item, in.Headers)
+			wg.Done()
+			return nil, nil
+		}),
+	)
+}
+
+func main() {
+	logger := fbp.NewZapLogger(zap.NewExample())
+
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "producer_to_printer.rec")
+
+	codec := fbp.NewJSONCodec()
+	codec.Register(number(0))
+
+	recorder, err := fbp.NewRecorder(path, codec, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Record
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	producer := fbp.NewPort("producer", nil, make(chan *fbp.InformationPackage))
+	recorded := printer(ctx, "recorded", &wg)
+	recorded.Stream()
+	conn := fbp.NewConnectionWith(ctx, "producer_to_printer")
+	conn.Record(recorder)
+	conn.StreamSingle(producer, recorded.Port())
+
+	for k := 1; k <= 5; k++ {
+		wg.Add(1)
+		ip := fbp.NewInformationPackage(fmt.Sprintf("ip%d", k), number(k))
+		ip.SetHeader("sent_at", time.Now().Format(time.StampMilli))
+		producer.Out <- ip
+		time.Sleep(100 * time.Millisecond)
+	}
+	wg.Wait()
+	cancel()
+	if err := recorder.Close(); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Replay
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	replayed := printer(ctx, "replayed", &wg)
+	replayed.Stream()
+	replayPort := fbp.NewPort("replay", nil, make(chan *fbp.InformationPackage))
+	fbp.NewConnectionWith(ctx, "replay_to_printer").StreamSingle(replayPort, replayed.Port())
+	wg.Add(5)
+	replay := fbp.NewReplay(ctx, "replay", replayPort, path, codec, 10, fbp.NewErrorHandler(logger), logger)
+	replay.Stream()
+	wg.Wait()
+	<-replay.Done()
+}
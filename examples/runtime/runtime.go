@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/theskyinflames/fbp"
+	"github.com/theskyinflames/set"
+)
+
+/*
+	This example exposes a registry of components through the FBP runtime
+	protocol. Point a protocol client like noflo-ui to ws://localhost:3569 to
+	build a graph, start it and watch the packages on its edges. When the
+	FBP_RUNTIME_SECRET environment variable is set, the clients must send it
+	as the runtime secret.
+
+	A "demo" graph is preloaded:
+
+	upper >--> printer
+*/
+
+const addr = "localhost:3569"
+
+type (
+	upperTask struct{}
+
+	printerTask struct{}
+)
+
+func (ut *upperTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	item, _ := in.Status.Iterator()()
+	out = &fbp.InformationPackage{
+		ID:     in.ID,
+		Status: &set.Set{},
+	}
+	out.Status.Add(func() string { return "upper" }, strings.ToUpper(fmt.Sprint(item)))
+	return
+}
+
+func (pt *printerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	item, _ := in.Status.Iterator()()
+	fmt.Printf("printer, package: %s, data: %v\n", in.ID, item)
+	return
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := fbp.NewZapLogger(zap.NewExample())
+	errorHandler := fbp.NewErrorHandler(logger)
+
+	registry := fbp.NewRegistry()
+	registry.Register(fbp.ComponentSpec{
+		Name:        "upper",
+		Description: "Converts the data to upper case",
+		New:         func() fbp.Task { return &upperTask{} },
+	})
+	registry.Register(fbp.ComponentSpec{
+		Name:        "printer",
+		Description: "Prints the data to the standard output",
+		New:         func() fbp.Task { return &printerTask{} },
+	})
+
+	network := fbp.NewNetwork(ctx, "demo", registry, errorHandler, logger)
+	network.AddNode("upper", "upper")
+	network.AddNode("printer", "printer")
+	network.AddEdge("upper", "printer")
+	network.AddInport("in", "upper")
+
+	server := fbp.NewRuntimeServer(ctx, registry, errorHandler, logger)
+	server.AddNetwork(network)
+	// noflo-ui is served from another origin, and sends the secret set on the
+	// runtime connection form
+	server.AllowOrigins("https://app.flowhub.io")
+	server.SetSecret(os.Getenv("FBP_RUNTIME_SECRET"))
+
+	fmt.Println("runtime listening on ws://" + addr)
+	if err := http.ListenAndServe(addr, server); err != nil {
+		logger.Error("serving runtime", fbp.Err(err))
+		os.Exit(1)
+	}
+}
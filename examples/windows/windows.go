@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/theskyinflames/fbp"
+	"github.com/theskyinflames/set"
+)
+
+/*
+	This example sums the amounts of the last day by the hour, using an event
+	time tumbling window instead of hand rolling the time filter
+
+	-->window-->writer
+*/
+
+const (
+	channelSz = 100
+)
+
+type (
+	Data struct {
+		Timestamp time.Time
+		Amount    int
+	}
+
+	Sum struct {
+		Bounds fbp.WindowBounds
+		Amount int
+	}
+
+	writerTask struct {
+		id     string
+		writer io.Writer
+	}
+)
+
+func (d Data) Key() func() string {
+	return func() string {
+		return fmt.Sprintf("%s_%d", fmt.Sprint(d.Timestamp), d.Amount)
+	}
+}
+
+func data(ip *fbp.InformationPackage) Data {
+	item, _ := ip.Status.Iterator()()
+	return item.(Data)
+}
+
+func sum(bounds fbp.WindowBounds, packages []*fbp.InformationPackage) *fbp.InformationPackage {
+	s := Sum{Bounds: bounds}
+	for _, ip := range packages {
+		s.Amount += data(ip).Amount
+	}
+	out := &fbp.InformationPackage{
+		ID:     fmt.Sprintf("sum_%d", bounds.Start.Unix()),
+		Status: &set.Set{},
+	}
+	out.Status.Add(func() string { return "sum" }, s)
+	return out
+}
+
+func (wt *writerTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	item, _ := in.Status.Iterator()()
+	s := item.(Sum)
+	wt.writer.Write([]byte(fmt.Sprintf("writer id:%s, from %s to %s, amount: %d\n", wt.id, s.Bounds.Start.Format(time.Kitchen), s.Bounds.End.Format(time.Kitchen), s.Amount)))
+	return
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := fbp.NewZapLogger(zap.NewExample())
+	errorHandler := fbp.NewErrorHandler(logger)
+
+	windowPort := fbp.NewPort(
+		"windowPort",
+		make(chan *fbp.InformationPackage, channelSz),
+		make(chan *fbp.InformationPackage, channelSz),
+	)
+	writerPort := fbp.NewPort(
+		"writerPort",
+		make(chan *fbp.InformationPackage, channelSz),
+		make(chan *fbp.InformationPackage, channelSz),
+	)
+
+	window, err := fbp.NewWindow(
+		ctx,
+		"hourly",
+		windowPort,
+		fbp.WindowSpec{
+			Kind:            fbp.TumblingWindow,
+			Domain:          fbp.EventTime,
+			Size:            time.Hour,
+			EventTime:       func(ip *fbp.InformationPackage) time.Time { return data(ip).Timestamp },
+			AllowedLateness: 30 * time.Minute,
+		},
+		sum,
+		errorHandler,
+		logger,
+	)
+	if err != nil {
+		logger.Error("creating window", fbp.Err(err))
+		os.Exit(1)
+	}
+	writer := fbp.NewComponent(ctx, "writer", writerPort, &writerTask{id: "writer", writer: os.Stdout}, errorHandler, logger)
+
+	window.Stream()
+	writer.Stream()
+	fbp.NewConnection(ctx, "fromWindowToWriter", logger).StreamSingle(windowPort, writerPort)
+
+	// A package every 10 minutes for the last day, a bit out of order. The
+	// last one arrives too late, after its window has been emitted
+	now := time.Now().Truncate(time.Hour)
+	for z := 24 * 6; z > 0; z-- {
+		ts := now.Add(time.Duration(-z*10) * time.Minute)
+		if z%2 == 0 {
+			ts = ts.Add(-15 * time.Minute)
+		}
+		windowPort.In <- fbp.NewInformationPackage(fmt.Sprintf("package_%d", z), Data{Timestamp: ts, Amount: 1})
+	}
+	windowPort.In <- fbp.NewInformationPackage("late", Data{Timestamp: now.Add(-3 * time.Hour), Amount: 100})
+
+	// Wait for a the process ends
+	time.Sleep(1 * time.Second)
+
+	os.Exit(0)
+}
@@ -0,0 +1,369 @@
+package fbp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/*
+	ParsePredicate compiles a small expression language into a Predicate:
+
+	expression := or
+	or         := and ("||" and)*
+	and        := unary ("&&" unary)*
+	unary      := "!" unary | "(" expression ")" | comparison
+	comparison := operand (("==" | "!=" | "<" | "<=" | ">" | ">=") operand)?
+	operand    := string | number | "true" | "false" | path
+	path       := ("id" | "header" "." name | "payload" ("." name)*)
+
+	Strings are quoted with double or single quotes. The payload paths are
+	resolved against the status items, using the first one that has the path
+	through struct fields or map keys. Operands that look like numbers are
+	compared as numbers, and the rest are compared as strings. A missing path
+	makes the comparisons false, but "!=".
+
+	header.type == "order" && (payload.Amount > 100 || header.priority == "high")
+*/
+
+type (
+	Predicate func(ip *InformationPackage) bool
+
+	operand func(ip *InformationPackage) (value interface{}, found bool)
+
+	token struct {
+		kind string
+		text string
+		pos  int
+	}
+
+	exprParser struct {
+		tokens []token
+		pos    int
+	}
+)
+
+const (
+	tokenString = "string"
+	tokenNumber = "number"
+	tokenIdent  = "ident"
+	tokenOp     = "op"
+	tokenEOF    = "eof"
+)
+
+func ParsePredicate(expression string) (predicate Predicate, err error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return
+	}
+	p := &exprParser{tokens: tokens}
+	predicate, err = p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return
+}
+
+func tokenize(expression string) (tokens []token, err error) {
+	runes := []rune(expression)
+	for k := 0; k < len(runes); {
+		r := runes[k]
+		switch {
+		case unicode.IsSpace(r):
+			k++
+		case r == '"' || r == '\'':
+			end := k + 1
+			var b strings.Builder
+			for ; end < len(runes) && runes[end] != r; end++ {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				b.WriteRune(runes[end])
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", k)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: b.String(), pos: k})
+			k = end + 1
+		case unicode.IsDigit(r) || (r == '-' && k+1 < len(runes) && unicode.IsDigit(runes[k+1])):
+			end := k + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[k:end]), pos: k})
+			k = end
+		case unicode.IsLetter(r) || r == '_':
+			end := k + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '.' || runes[end] == '-') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[k:end]), pos: k})
+			k = end
+		default:
+			op := string(r)
+			if k+1 < len(runes) {
+				switch two := string(runes[k : k+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			switch op {
+			case "==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")":
+			default:
+				return nil, fmt.Errorf("unexpected %q at %d", op, k)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: k})
+			k += len([]rune(op))
+		}
+	}
+	tokens = append(tokens, token{kind: tokenEOF, text: "end of expression", pos: len(runes)})
+	return
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) or() (Predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ip *InformationPackage) bool { return l(ip) || right(ip) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) and() (Predicate, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ip *InformationPackage) bool { return l(ip) && right(ip) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (Predicate, error) {
+	if p.accept("!") {
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(ip *InformationPackage) bool { return !inner(ip) }, nil
+	}
+	if p.accept("(") {
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			t := p.peek()
+			return nil, fmt.Errorf("expected ) at %d, got %q", t.pos, t.text)
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (Predicate, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+		if t.kind != tokenOp {
+			break
+		}
+		p.pos++
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return func(ip *InformationPackage) bool {
+			l, lok := left(ip)
+			r, rok := right(ip)
+			if !lok || !rok {
+				return t.text == "!="
+			}
+			return compare(l, r, t.text)
+		}, nil
+	}
+	return func(ip *InformationPackage) bool {
+		value, found := left(ip)
+		return found && truthy(value)
+	}, nil
+}
+
+func (p *exprParser) operand() (operand, error) {
+	t := p.peek()
+	p.pos++
+	switch t.kind {
+	case tokenString:
+		return constant(t.text), nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return constant(n), nil
+	case tokenIdent:
+		return path(t)
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func constant(value interface{}) operand {
+	return func(ip *InformationPackage) (interface{}, bool) { return value, true }
+}
+
+func path(t token) (operand, error) {
+	parts := strings.Split(t.text, ".")
+	switch {
+	case t.text == "true" || t.text == "false":
+		return constant(t.text == "true"), nil
+	case t.text == "id":
+		return func(ip *InformationPackage) (interface{}, bool) { return ip.ID, true }, nil
+	case (parts[0] == "header" || parts[0] == "headers") && len(parts) == 2:
+		return func(ip *InformationPackage) (interface{}, bool) {
+			value, found := ip.Headers[parts[1]]
+			return value, found
+		}, nil
+	case parts[0] == "payload":
+		return func(ip *InformationPackage) (interface{}, bool) {
+			for _, item := range statusItems(ip.Status) {
+				if value, found := lookup(reflect.ValueOf(item), parts[1:]); found {
+					return value, true
+				}
+			}
+			return nil, false
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown path %q at %d", t.text, t.pos)
+}
+
+func lookup(v reflect.Value, fields []string) (interface{}, bool) {
+	for _, field := range fields {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, false
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			f := v.FieldByName(field)
+			if !f.IsValid() {
+				f = v.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, field) })
+			}
+			if !f.IsValid() || !f.CanInterface() {
+				return nil, false
+			}
+			v = f
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return nil, false
+			}
+			v = v.MapIndex(reflect.ValueOf(field).Convert(v.Type().Key()))
+			if !v.IsValid() {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		n, err := strconv.ParseFloat(v.String(), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func compare(left interface{}, right interface{}, op string) bool {
+	var c int
+	ln, lok := toNumber(left)
+	rn, rok := toNumber(right)
+	if lok && rok {
+		switch {
+		case ln < rn:
+			c = -1
+		case ln > rn:
+			c = 1
+		}
+	} else {
+		c = strings.Compare(fmt.Sprint(left), fmt.Sprint(right))
+	}
+
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false"
+	case nil:
+		return false
+	}
+	if n, ok := toNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
@@ -0,0 +1,412 @@
+// Package fbptest helps testing tasks, components and networks. A Harness
+// feeds packages to named in ports, collects the packages sent to named out
+// ports, captures the errors and the events, drives a fake clock and checks
+// that nothing keeps running after it's closed
+package fbptest
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+// DefaultTimeout is how long the harness waits for the packages, the errors
+// and the events before failing the test
+const DefaultTimeout = time.Second
+
+// Epoch is the time the fake clock of the harness starts at
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// fbpFrame is found in the stacks of the goroutines running the fbp code
+var fbpFrame = reflect.TypeOf(fbp.Port{}).PkgPath() + "."
+
+type (
+	Harness struct {
+		t       testing.TB
+		ctx     context.Context
+		cancel  context.CancelFunc
+		timeout time.Duration
+
+		Clock        *fbp.FakeClock
+		Bus          *fbp.EventBus
+		ErrorHandler *fbp.ErrorHandler
+		Logger       fbp.Logger
+
+		baseline    map[string]bool
+		unsubscribe func()
+		readers     sync.WaitGroup
+		closed      bool
+		shuffle     fbp.Option
+
+		mux      sync.Mutex
+		changed  *sync.Cond
+		inports  map[string]func(ctx context.Context, ip *fbp.InformationPackage) error
+		outports map[string][]*fbp.InformationPackage
+		errors   []error
+		events   []fbp.Event
+	}
+)
+
+// New creates a harness that's closed when the test ends
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	h := &Harness{
+		t:        t,
+		timeout:  DefaultTimeout,
+		Clock:    fbp.NewFakeClock(Epoch),
+		Bus:      fbp.NewEventBus(),
+		Logger:   fbp.NewNopLogger(),
+		baseline: make(map[string]bool),
+		inports:  make(map[string]func(ctx context.Context, ip *fbp.InformationPackage) error),
+		outports: make(map[string][]*fbp.InformationPackage),
+	}
+	for id := range fbpGoroutines() {
+		h.baseline[id] = true
+	}
+	h.changed = sync.NewCond(&h.mux)
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.Bus.SetClock(h.Clock)
+	h.ErrorHandler = fbp.NewErrorHandlerFunc(h.Logger, func(err error) {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		h.errors = append(h.errors, err)
+		h.changed.Broadcast()
+	})
+
+	events, unsubscribe := h.Bus.Subscribe(1024)
+	h.unsubscribe = unsubscribe
+	h.readers.Add(1)
+	go func() {
+		defer h.readers.Done()
+		for event := range events {
+			h.mux.Lock()
+			h.events = append(h.events, event)
+			h.changed.Broadcast()
+			h.mux.Unlock()
+		}
+	}()
+
+	t.Cleanup(h.Close)
+	return h
+}
+
+// Context returns the context of the harness, canceled when it's closed
+func (h *Harness) Context() context.Context {
+	return h.ctx
+}
+
+// SetTimeout sets how long the harness waits before failing the test
+func (h *Harness) SetTimeout(timeout time.Duration) {
+	h.timeout = timeout
+}
+
+// Options returns the options that make the components, connections and
+// networks use the clock, the bus, the error handler and the logger of the
+// harness, and shuffle them in the Shuffled runs
+func (h *Harness) Options() []fbp.Option {
+	opts := []fbp.Option{
+		fbp.WithClock(h.Clock),
+		fbp.WithEventBus(h.Bus),
+		fbp.WithErrorHandler(h.ErrorHandler),
+		fbp.WithLogger(h.Logger),
+	}
+	if h.shuffle != nil {
+		opts = append(opts, h.shuffle)
+	}
+	return opts
+}
+
+// Component creates a component with the harness options followed by opts,
+// starts streaming it and names both its in port and its out port after it
+func (h *Harness) Component(id string, task fbp.Task, opts ...fbp.Option) *fbp.Component {
+	c := fbp.NewComponentWith(h.ctx, id, task, append(h.Options(), opts...)...)
+	c.Stream()
+	h.Inport(id, c.Port())
+	h.Outport(id, c.Port())
+	return c
+}
+
+// Inport names the in channel of a port to feed it
+func (h *Harness) Inport(name string, port *fbp.Port) {
+	h.addInport(name, func(ctx context.Context, ip *fbp.InformationPackage) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case port.In <- ip:
+			return nil
+		}
+	})
+}
+
+// NetworkInport names a public in port of a network to feed it
+func (h *Harness) NetworkInport(name string, network *fbp.Network, public string) {
+	h.addInport(name, func(ctx context.Context, ip *fbp.InformationPackage) error {
+		sent := make(chan error, 1)
+		go func() {
+			sent <- network.Send(public, ip)
+		}()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-sent:
+			return err
+		}
+	})
+}
+
+func (h *Harness) addInport(name string, feed func(ctx context.Context, ip *fbp.InformationPackage) error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.inports[name] = feed
+}
+
+// Outport names the out channel of a port, collecting all the packages sent to it
+func (h *Harness) Outport(name string, port *fbp.Port) {
+	h.collect(name, port.Out)
+}
+
+// NetworkOutport names a public out port of a running network, collecting
+// all the packages sent to it
+func (h *Harness) NetworkOutport(name string, network *fbp.Network, public string) {
+	h.t.Helper()
+
+	out, err := network.Outport(public)
+	if err != nil {
+		h.t.Fatalf("fbptest: out port %s: %s", public, err)
+	}
+	h.collect(name, out)
+}
+
+func (h *Harness) collect(name string, out chan *fbp.InformationPackage) {
+	h.mux.Lock()
+	if _, ok := h.outports[name]; !ok {
+		h.outports[name] = nil
+	}
+	h.mux.Unlock()
+
+	h.readers.Add(1)
+	go func() {
+		defer h.readers.Done()
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case ip, ok := <-out:
+				if !ok {
+					return
+				}
+				h.mux.Lock()
+				h.outports[name] = append(h.outports[name], ip)
+				h.changed.Broadcast()
+				h.mux.Unlock()
+			}
+		}
+	}()
+}
+
+// Feed sends the packages to a named in port, failing the test if they
+// aren't accepted in time
+func (h *Harness) Feed(name string, ips ...*fbp.InformationPackage) {
+	h.t.Helper()
+
+	h.mux.Lock()
+	feed, ok := h.inports[name]
+	h.mux.Unlock()
+	if !ok {
+		h.t.Fatalf("fbptest: unknown in port %s", name)
+	}
+
+	for _, ip := range ips {
+		ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+		err := feed(ctx, ip)
+		cancel()
+		if err != nil {
+			h.t.Fatalf("fbptest: feeding package %s to %s: %s", ip.ID, name, err)
+		}
+	}
+}
+
+// Collect waits for n packages from a named out port, and returns them
+// removing them from the port. It fails the test if they don't arrive in time
+func (h *Harness) Collect(name string, n int) []*fbp.InformationPackage {
+	h.t.Helper()
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if _, ok := h.outports[name]; !ok {
+		h.t.Fatalf("fbptest: unknown out port %s", name)
+	}
+	if !h.wait(func() bool { return len(h.outports[name]) >= n }) {
+		h.t.Fatalf("fbptest: collected %d packages from %s, want %d", len(h.outports[name]), name, n)
+	}
+	ips := h.outports[name][:n:n]
+	h.outports[name] = h.outports[name][n:]
+	return ips
+}
+
+// ExpectNone fails the test if a named out port receives any package for d,
+// as soon as it does
+func (h *Harness) ExpectNone(name string, d time.Duration) {
+	h.t.Helper()
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if h.waitFor(d, func() bool { return len(h.outports[name]) > 0 }) {
+		ips := h.outports[name]
+		h.t.Fatalf("fbptest: %s received %d unexpected packages, the first one %s", name, len(ips), ips[0].ID)
+	}
+}
+
+// Errors waits for n errors to be handled, and returns all of them
+func (h *Harness) Errors(n int) []error {
+	h.t.Helper()
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if !h.wait(func() bool { return len(h.errors) >= n }) {
+		h.t.Fatalf("fbptest: handled %d errors, want %d", len(h.errors), n)
+	}
+	return append([]error(nil), h.errors...)
+}
+
+// WaitEvent waits for an event of the kind published by the source, and
+// returns it. An empty source matches any of them
+func (h *Harness) WaitEvent(kind fbp.EventKind, source string) fbp.Event {
+	h.t.Helper()
+
+	var found fbp.Event
+	match := func() bool {
+		for _, event := range h.events {
+			if event.Kind == kind && (source == "" || event.Source == source) {
+				found = event
+				return true
+			}
+		}
+		return false
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	if !h.wait(match) {
+		h.t.Fatalf("fbptest: no %s event from %q", kind, source)
+	}
+	return found
+}
+
+// Events returns the events published so far
+func (h *Harness) Events() []fbp.Event {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	return append([]fbp.Event(nil), h.events...)
+}
+
+// Advance waits for the components to be waiting for n timers or tickers of
+// the fake clock, and then moves it forward
+func (h *Harness) Advance(d time.Duration, waiters int) {
+	h.t.Helper()
+
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	defer cancel()
+	if err := h.Clock.BlockUntilContext(ctx, waiters); err != nil {
+		h.t.Fatalf("fbptest: nothing waiting for %d timers of the clock", waiters)
+	}
+	h.Clock.Advance(d)
+}
+
+// wait must be called holding the lock. It waits until the condition holds
+// or the timeout expires
+func (h *Harness) wait(condition func() bool) bool {
+	return h.waitFor(h.timeout, condition)
+}
+
+// waitFor is wait with its own timeout
+func (h *Harness) waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		h.mux.Lock()
+		defer h.mux.Unlock()
+		h.changed.Broadcast()
+	})
+	defer timer.Stop()
+
+	for !condition() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		h.changed.Wait()
+	}
+	return true
+}
+
+// Close stops everything started with the harness context, and fails the
+// test if there are goroutines left over running the fbp code, which were
+// not there when the harness was created. It's called when the test ends
+func (h *Harness) Close() {
+	h.t.Helper()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	h.cancel()
+	h.unsubscribe()
+	h.readers.Wait()
+
+	deadline := time.Now().Add(h.timeout)
+	for leaked := h.leaked(); len(leaked) > 0; leaked = h.leaked() {
+		if time.Now().After(deadline) {
+			h.t.Errorf("fbptest: %d goroutines leaked:\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// leaked returns the stacks of the goroutines running the fbp code started
+// after the harness
+func (h *Harness) leaked() (stacks []string) {
+	for id, stack := range fbpGoroutines() {
+		if !h.baseline[id] {
+			stacks = append(stacks, stack)
+		}
+	}
+	return
+}
+
+// fbpGoroutines returns the stacks of the goroutines running the fbp code,
+// by goroutine id
+func fbpGoroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	goroutines := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		// Each stack starts with a "goroutine <id> [<status>]:" line
+		header := strings.Fields(stack)
+		if len(header) < 2 || !strings.Contains(stack, fbpFrame) {
+			continue
+		}
+		goroutines[header[1]] = stack
+	}
+	return goroutines
+}
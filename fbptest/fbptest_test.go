@@ -0,0 +1,108 @@
+package fbptest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+type word string
+
+func (w word) Key() func() string {
+	return func() string {
+		return string(w)
+	}
+}
+
+func upper() fbp.Task {
+	return fbp.ContextTaskFunc(func(ctx context.Context, in *fbp.InformationPackage) (*fbp.InformationPackage, error) {
+		item, _ := in.Status.Iterator()()
+		return fbp.NewInformationPackage(in.ID, word(strings.ToUpper(string(item.(word))))), nil
+	})
+}
+
+func TestHarnessFeedCollect(t *testing.T) {
+	h := New(t)
+	h.Component("upper", upper())
+
+	h.Feed("upper",
+		fbp.NewInformationPackage("ip1", word("foo")),
+		fbp.NewInformationPackage("ip2", word("bar")),
+	)
+	ips := h.Collect("upper", 2)
+
+	for k, want := range []word{"FOO", "BAR"} {
+		item, _ := ips[k].Status.Iterator()()
+		if item != want {
+			t.Errorf("package %d: got %v, want %v", k+1, item, want)
+		}
+	}
+	h.ExpectNone("upper", 10*time.Millisecond)
+}
+
+func TestHarnessLeak(t *testing.T) {
+	h := New(t)
+
+	// The component isn't started with the harness context, so it's still
+	// running after the harness is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	fbp.NewComponentWith(ctx, "leaky", upper()).Stream()
+
+	deadline := time.Now().Add(DefaultTimeout)
+	leaked := h.leaked()
+	for ; len(leaked) == 0 && time.Now().Before(deadline); leaked = h.leaked() {
+		time.Sleep(time.Millisecond)
+	}
+	if len(leaked) == 0 {
+		t.Fatal("the leaked component goroutines were not found")
+	}
+	if !strings.Contains(strings.Join(leaked, "\n"), "(*Component).Stream") {
+		t.Errorf("the leaked goroutines are not the component ones:\n%s", strings.Join(leaked, "\n\n"))
+	}
+
+	// Stopping it before the harness is closed fixes the leak
+	cancel()
+}
+
+// fatalRecorder keeps the fatal failures instead of ending the test
+type fatalRecorder struct {
+	testing.TB
+	fatal string
+}
+
+func (fr *fatalRecorder) Fatalf(format string, args ...interface{}) {
+	fr.fatal = fmt.Sprintf(format, args...)
+}
+
+func TestHarnessExpectNone(t *testing.T) {
+	recorder := &fatalRecorder{TB: t}
+	h := New(recorder)
+	h.Component("upper", upper())
+	h.Feed("upper", fbp.NewInformationPackage("ip1", word("foo")))
+
+	// It fails as soon as the package arrives
+	start := time.Now()
+	h.ExpectNone("upper", time.Hour)
+	if recorder.fatal == "" {
+		t.Fatal("the unexpected package was not reported")
+	}
+	if elapsed := time.Since(start); elapsed > DefaultTimeout {
+		t.Errorf("reported after %s", elapsed)
+	}
+}
+
+func TestHarnessAdvanceTimeout(t *testing.T) {
+	// The harness fails the test if waiting for the timers leaks a goroutine
+	recorder := &fatalRecorder{TB: t}
+	h := New(recorder)
+	h.SetTimeout(10 * time.Millisecond)
+
+	h.Advance(time.Second, 1)
+	if recorder.fatal == "" {
+		t.Fatal("the missing timer was not reported")
+	}
+}
@@ -0,0 +1,225 @@
+package fbptest
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+// DefaultQuiet is how long an out port must go without packages for a
+// golden run to end
+const DefaultQuiet = 100 * time.Millisecond
+
+// DefaultShuffleDelay is the max delay of the shuffled runs
+const DefaultShuffleDelay = time.Millisecond
+
+var (
+	update = flag.Bool("fbptest.update", false, "update the fbptest golden files")
+	seed   = flag.Int64("fbptest.seed", 0, "run the fbptest shuffled tests only with this seed")
+)
+
+// GoldenRun feeds the packages of testdata/<Name>.input to the in port, and
+// compares the packages collected from the out port to testdata/<Name>.golden.
+// Both files have a package per line, encoded with the JSON codec
+type GoldenRun struct {
+	Name    string
+	Inport  string
+	Outport string
+	Codec   *fbp.JSONCodec
+	// Unordered compares the packages regardless of their order
+	Unordered bool
+	// Quiet ends the run when the out port goes without packages for it.
+	// By default it's DefaultQuiet
+	Quiet time.Duration
+}
+
+// ReadPackages reads a file with a package per line encoded by the codec
+func ReadPackages(t testing.TB, path string, codec fbp.Codec) (ips []*fbp.InformationPackage) {
+	t.Helper()
+
+	for _, line := range readLines(t, path) {
+		ip, err := codec.Decode([]byte(line.text))
+		if err != nil {
+			t.Fatalf("fbptest: %s:%d: %s", path, line.number, err)
+		}
+		ips = append(ips, ip)
+	}
+	return
+}
+
+// goldenLine is a not blank line of a packages file
+type goldenLine struct {
+	number int
+	text   string
+}
+
+func readLines(t testing.TB, path string) (lines []goldenLine) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("fbptest: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		text := string(bytes.TrimSpace(scanner.Bytes()))
+		if text == "" {
+			continue
+		}
+		lines = append(lines, goldenLine{number: number, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("fbptest: %s: %s", path, err)
+	}
+	return
+}
+
+// WritePackages writes a file with a package per line encoded by the codec
+func WritePackages(t testing.TB, path string, codec fbp.Codec, ips []*fbp.InformationPackage) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("fbptest: %s", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(encode(t, codec, ips), "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("fbptest: %s", err)
+	}
+}
+
+// AssertGolden compares the packages, encoded by the codec, to the lines of
+// the golden file, or rewrites it when the tests run with -fbptest.update.
+// The golden file isn't decoded, so the package data types don't need to be
+// registered in the codec. The sequence numbers are left out, as they depend
+// on the scheduling
+func AssertGolden(t testing.TB, path string, codec fbp.Codec, ips []*fbp.InformationPackage, unordered bool) {
+	t.Helper()
+
+	unsequenced := make([]*fbp.InformationPackage, len(ips))
+	for k, ip := range ips {
+		unsequenced[k] = fbp.CloneHeaders(ip)
+		unsequenced[k].Seq = 0
+	}
+	if *update {
+		WritePackages(t, path, codec, unsequenced)
+		return
+	}
+
+	got := encode(t, codec, unsequenced)
+	var want []string
+	for _, line := range readLines(t, path) {
+		want = append(want, line.text)
+	}
+	if unordered {
+		sort.Strings(got)
+		sort.Strings(want)
+	}
+	for k := 0; k < len(got) || k < len(want); k++ {
+		switch {
+		case k >= len(want):
+			t.Fatalf("fbptest: %s: unexpected package %d:\n%s", path, k+1, got[k])
+		case k >= len(got):
+			t.Fatalf("fbptest: %s: missing package %d:\n%s", path, k+1, want[k])
+		case got[k] != want[k]:
+			t.Fatalf("fbptest: %s: package %d differs:\ngot:  %s\nwant: %s", path, k+1, got[k], want[k])
+		}
+	}
+}
+
+func encode(t testing.TB, codec fbp.Codec, ips []*fbp.InformationPackage) []string {
+	t.Helper()
+
+	lines := make([]string, len(ips))
+	for k, ip := range ips {
+		data, err := codec.Encode(ip)
+		if err != nil {
+			t.Fatalf("fbptest: encoding package %s: %s", ip.ID, err)
+		}
+		lines[k] = string(data)
+	}
+	return lines
+}
+
+// Golden runs the graph under test over the recorded input of the run and
+// checks its output against the golden file
+func (h *Harness) Golden(run GoldenRun) {
+	h.t.Helper()
+
+	codec := run.Codec
+	if codec == nil {
+		codec = fbp.NewJSONCodec()
+	}
+	quiet := run.Quiet
+	if quiet <= 0 {
+		quiet = DefaultQuiet
+	}
+
+	h.Feed(run.Inport, ReadPackages(h.t, filepath.Join("testdata", run.Name+".input"), codec)...)
+	AssertGolden(h.t, filepath.Join("testdata", run.Name+".golden"), codec, h.CollectUntilQuiet(run.Outport, quiet), run.Unordered)
+}
+
+// CollectUntilQuiet waits until a named out port goes without packages for
+// quiet, and returns all the packages it got, removing them from the port
+func (h *Harness) CollectUntilQuiet(name string, quiet time.Duration) []*fbp.InformationPackage {
+	h.t.Helper()
+
+	h.mux.Lock()
+	if _, ok := h.outports[name]; !ok {
+		h.mux.Unlock()
+		h.t.Fatalf("fbptest: unknown out port %s", name)
+	}
+	h.mux.Unlock()
+
+	for count := -1; ; {
+		time.Sleep(quiet)
+		h.mux.Lock()
+		n := len(h.outports[name])
+		if n == count {
+			ips := h.outports[name]
+			h.outports[name] = nil
+			h.mux.Unlock()
+			return ips
+		}
+		h.mux.Unlock()
+		count = n
+	}
+}
+
+// Shuffled runs f once per seed with a harness whose Options shuffle the
+// scheduling and the fan out choices, so the tests relying on a given order
+// of the packages fail. The subtests are named after their seed, and the
+// -fbptest.seed flag runs only the given one to reproduce a failure
+func Shuffled(t *testing.T, runs int, f func(t *testing.T, h *Harness)) {
+	t.Helper()
+
+	seeds := make([]int64, 0, runs)
+	if *seed != 0 {
+		seeds = append(seeds, *seed)
+	} else {
+		base := time.Now().UnixNano()
+		for k := 0; k < runs; k++ {
+			seeds = append(seeds, base+int64(k))
+		}
+	}
+
+	for _, s := range seeds {
+		s := s
+		t.Run(fmt.Sprintf("seed=%d", s), func(t *testing.T) {
+			h := New(t)
+			h.shuffle = fbp.WithShuffle(s, DefaultShuffleDelay)
+			f(t, h)
+		})
+	}
+}
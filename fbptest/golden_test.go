@@ -0,0 +1,106 @@
+package fbptest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theskyinflames/fbp"
+)
+
+func wordCodec() *fbp.JSONCodec {
+	codec := fbp.NewJSONCodec()
+	codec.Register(word(""))
+	return codec
+}
+
+func upperRun(unordered bool) GoldenRun {
+	return GoldenRun{
+		Name:      "upper",
+		Inport:    "upper",
+		Outport:   "upper",
+		Codec:     wordCodec(),
+		Unordered: unordered,
+	}
+}
+
+func TestAssertGoldenUnregisteredTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.golden")
+	codec := fbp.NewJSONCodec()
+	ips := []*fbp.InformationPackage{
+		fbp.NewInformationPackage("ip1", word("foo")),
+		fbp.NewInformationPackage("ip2", word("bar")),
+	}
+
+	// word isn't registered in the codec, so the golden file can't be decoded
+	WritePackages(t, path, codec, ips)
+	ips[0].Seq = 7
+	AssertGolden(t, path, codec, ips, false)
+	AssertGolden(t, path, codec, []*fbp.InformationPackage{ips[1], ips[0]}, true)
+	if ips[0].Seq != 7 {
+		t.Errorf("got sequence number %d, want it left as 7", ips[0].Seq)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	h := New(t)
+	h.Component("upper", upper())
+	h.Golden(upperRun(false))
+}
+
+func TestGoldenUpdate(t *testing.T) {
+	input, err := ioutil.ReadFile(filepath.Join("testdata", "upper.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "upper.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The run reads and writes the testdata of the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Mkdir(filepath.Join(dir, "testdata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "testdata", "upper.input"), input, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// As if it ran with -fbptest.update
+	defer func(updating bool) { *update = updating }(*update)
+	*update = true
+	h := New(t)
+	h.Component("upper", upper())
+	h.Golden(upperRun(false))
+	*update = false
+
+	got, err := ioutil.ReadFile(filepath.Join("testdata", "upper.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got golden file:\n%s\nwant:\n%s", got, want)
+	}
+
+	h = New(t)
+	h.Component("upper", upper())
+	h.Golden(upperRun(false))
+}
+
+func TestShuffled(t *testing.T) {
+	// The workers may send the packages in any order
+	Shuffled(t, 3, func(t *testing.T, h *Harness) {
+		h.Component("upper", upper(), fbp.WithConcurrency(3))
+		h.Golden(upperRun(true))
+	})
+}
@@ -0,0 +1,116 @@
+package fbp
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const DefaultHashRingReplicas = 128
+
+// HashRing assigns keys to members by consistent hashing. Each member is
+// placed many times around the ring, so when a member is added or removed
+// only the keys of its arcs move, and the rest keep their assignment
+type HashRing struct {
+	replicas int
+	mux      sync.RWMutex
+	hashes   []uint32
+	owners   map[uint32]string
+	members  map[string]bool
+}
+
+func NewHashRing(replicas int, members ...string) *HashRing {
+	if replicas < 1 {
+		replicas = DefaultHashRingReplicas
+	}
+	r := &HashRing{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		members:  make(map[string]bool),
+	}
+	for _, member := range members {
+		r.Add(member)
+	}
+	return r
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	// FNV spreads similar keys poorly, so the result goes through the
+	// murmur3 finalizer to get a uniform ring
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+func (r *HashRing) Add(member string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.members[member] {
+		return
+	}
+	r.members[member] = true
+	for k := 0; k < r.replicas; k++ {
+		h := hashKey(member + "#" + strconv.Itoa(k))
+		if _, ok := r.owners[h]; ok {
+			continue
+		}
+		r.owners[h] = member
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *HashRing) Remove(member string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if !r.members[member] {
+		return
+	}
+	delete(r.members, member)
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == member {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// Get returns the member owning the key, or an empty string if the ring is empty
+func (r *HashRing) Get(key string) string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	k := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if k == len(r.hashes) {
+		k = 0
+	}
+	return r.owners[r.hashes[k]]
+}
+
+func (r *HashRing) Members() (members []string) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for member := range r.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return
+}
@@ -1,6 +1,7 @@
 package fbp
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/theskyinflames/set"
@@ -23,7 +24,87 @@ func NewInformationPackage(ID string, data KeyGetter) *InformationPackage {
 	return ip
 }
 
+// NewBarrier returns the barrier package of a checkpoint. Barriers are not
+// processed by the tasks, they just flow through the graph to delimit the
+// packages that belong to each checkpoint
+func NewBarrier(checkpointID int64) *InformationPackage {
+	ip := NewInformationPackage(fmt.Sprintf("barrier_%d", checkpointID), nil)
+	ip.Barrier = checkpointID
+	return ip
+}
+
 type InformationPackage struct {
-	ID     string
-	Status *set.Set
+	ID      string
+	Status  *set.Set
+	Headers map[string]string
+	Barrier int64
+	// Seq is the sequence number assigned by the first dispatch connection
+	// the package goes through, used to merge the packages back in order
+	Seq uint64
+}
+
+func (ip *InformationPackage) IsBarrier() bool {
+	return ip.Barrier != 0
+}
+
+func (ip *InformationPackage) Header(key string) string {
+	return ip.Headers[key]
+}
+
+func (ip *InformationPackage) SetHeader(key string, value string) {
+	if ip.Headers == nil {
+		ip.Headers = make(map[string]string)
+	}
+	ip.Headers[key] = value
+}
+
+// inherit carries the headers and the sequence number of the package a task
+// received over to the package it returned, when the task didn't set them
+func (ip *InformationPackage) inherit(from *InformationPackage) {
+	if ip == from {
+		return
+	}
+	if ip.Seq == 0 {
+		ip.Seq = from.Seq
+	}
+	if ip.Headers == nil && from.Headers != nil {
+		ip.Headers = copyHeaders(from.Headers)
+	}
+}
+
+func copyHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	c := make(map[string]string, len(headers))
+	for k, v := range headers {
+		c[k] = v
+	}
+	return c
+}
+
+// CloneHeaders returns a new package sharing the status set of ip, so the
+// items keep their keys, with its own copy of the headers. The items must not
+// be changed through the clone while ip is in use
+func CloneHeaders(ip *InformationPackage) *InformationPackage {
+	return &InformationPackage{
+		ID:      ip.ID,
+		Status:  ip.Status,
+		Headers: copyHeaders(ip.Headers),
+		Barrier: ip.Barrier,
+		Seq:     ip.Seq,
+	}
+}
+
+// ShallowClone returns a new package with its own status set holding the
+// same items. The set doesn't expose its keys, so the items keep their key
+// only when they implement KeyGetter
+func ShallowClone(ip *InformationPackage) *InformationPackage {
+	return &InformationPackage{
+		ID:      ip.ID,
+		Status:  newStatus(statusItems(ip.Status)),
+		Headers: copyHeaders(ip.Headers),
+		Barrier: ip.Barrier,
+		Seq:     ip.Seq,
+	}
 }
@@ -0,0 +1,318 @@
+package fbp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// InnerJoin only emits the packages correlated from all the inputs
+	InnerJoin JoinKind = iota
+	// LeftJoin also emits the partial matches having a package from the first input
+	LeftJoin
+	// OuterJoin emits all the partial matches
+	OuterJoin
+)
+
+var ErrUnmatchedPackage = errors.New("package could not be correlated")
+
+type (
+	JoinKind int
+
+	JoinSpec struct {
+		Kind JoinKind
+		// Key returns the correlation key of the packages
+		Key func(ip *InformationPackage) string
+		// MaxPending bounds the keys waiting for their matches. When it's
+		// reached, the oldest key is handled as if it had timed out
+		MaxPending int
+		// Timeout is how long a key waits for its matches, measured with the
+		// component clock. Zero waits forever
+		Timeout time.Duration
+		// Codec encodes the packages waiting for their matches in the
+		// checkpoints. It's required to checkpoint the join
+		Codec Codec
+	}
+
+	// Joined is the status item of the packages emitted by a join, with
+	// the correlated packages in the order of the inputs. The missing ones
+	// of partial matches are nil
+	Joined struct {
+		CorrelationKey string
+		Packages       []*InformationPackage
+	}
+
+	joinEntry struct {
+		key      string
+		packages []*InformationPackage
+		created  time.Time
+	}
+
+	joinSnapshot struct {
+		Key      string
+		Packages [][]byte
+		Created  time.Time
+	}
+
+	joinInput struct {
+		k                  int
+		informationPackage *InformationPackage
+	}
+
+	// Join is the task of a component that correlates by key the packages
+	// received from several in ports, sending a package with all of them by
+	// the component out channel. The unmatched packages are sent by the out
+	// channel of the component error port, or handled as errors when there
+	// isn't any
+	Join struct {
+		streamer
+		inports []*Port
+		spec    JoinSpec
+
+		c       *Component
+		pending map[string]*joinEntry
+		order   []string
+	}
+)
+
+func (j Joined) Key() func() string {
+	return func() string {
+		return "joined_" + j.CorrelationKey
+	}
+}
+
+// NewJoin creates a join of the in channels of the inports. The in channel
+// of its component is not read. The join stops when all the inports are
+// closed, closing first the pending correlations
+func NewJoin(inports []*Port, spec JoinSpec) (j *Join, err error) {
+	if len(inports) < 2 {
+		return nil, errors.New("join requires at least two in ports")
+	}
+	if spec.Key == nil {
+		return nil, errors.New("join requires a correlation key")
+	}
+	if spec.MaxPending < 1 {
+		return nil, errors.New("join requires a max of pending keys")
+	}
+	j = &Join{
+		inports: inports,
+		spec:    spec,
+		pending: make(map[string]*joinEntry),
+	}
+	return
+}
+
+// Snapshot returns the pending correlations, oldest first
+func (j *Join) Snapshot() (state []byte, err error) {
+	snapshot := make([]joinSnapshot, 0, len(j.order))
+	for _, key := range j.order {
+		entry := j.pending[key]
+		packages, err := encodePackages(j.spec.Codec, entry.packages)
+		if err != nil {
+			return nil, err
+		}
+		snapshot = append(snapshot, joinSnapshot{Key: key, Packages: packages, Created: entry.created})
+	}
+	return json.Marshal(snapshot)
+}
+
+func (j *Join) Restore(state []byte) (err error) {
+	var snapshot []joinSnapshot
+	if err = json.Unmarshal(state, &snapshot); err != nil {
+		return
+	}
+	pending := make(map[string]*joinEntry, len(snapshot))
+	order := make([]string, 0, len(snapshot))
+	for _, entry := range snapshot {
+		if len(entry.Packages) != len(j.inports) {
+			return fmt.Errorf("join key %s: snapshot of %d in ports, want %d", entry.Key, len(entry.Packages), len(j.inports))
+		}
+		packages, err := decodePackages(j.spec.Codec, entry.Packages)
+		if err != nil {
+			return err
+		}
+		pending[entry.Key] = &joinEntry{key: entry.Key, packages: packages, created: entry.Created}
+		order = append(order, entry.Key)
+	}
+	j.pending, j.order = pending, order
+	return
+}
+
+func (j *Join) stream(c *Component) {
+	j.c = c
+	inputs := make(chan joinInput)
+	aligner := newBarrierAligner(len(j.inports))
+	for k := range j.inports {
+		go j.read(k, inputs, aligner)
+	}
+	open := len(j.inports)
+
+	c.logger.Info("join starting", Int("in", len(j.inports)))
+
+	var tick <-chan time.Time
+	if j.spec.Timeout > 0 {
+		ticker := c.clock.NewTicker(j.spec.Timeout / 2)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-tick:
+			// The clock is read again, as the tick may have waited
+			if !j.expire(c.clock.Now().Add(-j.spec.Timeout)) {
+				return
+			}
+		case input := <-inputs:
+			if input.informationPackage == nil {
+				if open--; open > 0 {
+					continue
+				}
+				// All the in ports are closed, so the matches won't arrive
+				for len(j.order) > 0 && j.close(j.pending[j.order[0]]) {
+				}
+				return
+			}
+			if input.informationPackage.IsBarrier() {
+				// The component saves the pending packages, as the join is Stateful
+				c.checkpoint(input.informationPackage)
+				if !c.send(input.informationPackage) {
+					return
+				}
+				continue
+			}
+			if !j.add(input.k, input.informationPackage) {
+				return
+			}
+		}
+	}
+}
+
+// read forwards the packages of an in port, holding it after a barrier until
+// the barrier has arrived from all the in ports
+func (j *Join) read(k int, inputs chan joinInput, aligner *barrierAligner) {
+	for {
+		select {
+		case <-j.c.ctx.Done():
+			return
+		case informationPackage, ok := <-j.inports[k].In:
+			if !ok {
+				j.c.logger.Warn("in port closed", String("port_id", j.inports[k].ID))
+				// A nil package tells the join the in port is closed
+				select {
+				case <-j.c.ctx.Done():
+				case inputs <- joinInput{k: k}:
+				}
+				return
+			}
+			if informationPackage.IsBarrier() {
+				last, aligned := aligner.arrive(informationPackage.Barrier)
+				if !last {
+					select {
+					case <-j.c.ctx.Done():
+						return
+					case <-aligned:
+					}
+					continue
+				}
+			}
+			select {
+			case <-j.c.ctx.Done():
+				return
+			case inputs <- joinInput{k: k, informationPackage: informationPackage}:
+			}
+		}
+	}
+}
+
+func (j *Join) add(k int, informationPackage *InformationPackage) bool {
+	key := j.spec.Key(informationPackage)
+	entry, ok := j.pending[key]
+	if ok && entry.packages[k] != nil {
+		// A new package for the same key closes the previous correlation
+		if !j.close(entry) {
+			return false
+		}
+		ok = false
+	}
+	if !ok {
+		if len(j.pending) >= j.spec.MaxPending {
+			if !j.close(j.pending[j.order[0]]) {
+				return false
+			}
+		}
+		entry = &joinEntry{
+			key:      key,
+			packages: make([]*InformationPackage, len(j.inports)),
+			created:  j.c.clock.Now(),
+		}
+		j.pending[key] = entry
+		j.order = append(j.order, key)
+	}
+
+	entry.packages[k] = informationPackage
+	for _, p := range entry.packages {
+		if p == nil {
+			return true
+		}
+	}
+	j.remove(key)
+	return j.c.send(j.joined(entry))
+}
+
+// expire closes the correlations created before the deadline
+func (j *Join) expire(deadline time.Time) bool {
+	for len(j.order) > 0 && j.pending[j.order[0]].created.Before(deadline) {
+		if !j.close(j.pending[j.order[0]]) {
+			return false
+		}
+	}
+	return true
+}
+
+// close gives up waiting for the matches of a key, emitting the partial
+// match or sending its packages to the error port depending on the join kind
+func (j *Join) close(entry *joinEntry) bool {
+	j.remove(entry.key)
+	if j.spec.Kind == OuterJoin || (j.spec.Kind == LeftJoin && entry.packages[0] != nil) {
+		return j.c.send(j.joined(entry))
+	}
+
+	for _, informationPackage := range entry.packages {
+		if informationPackage == nil {
+			continue
+		}
+		if j.c.errorPort == nil {
+			j.c.errorHandler.Handle(fmt.Errorf("join %s, key %s, package %s: %w", j.c.id, entry.key, informationPackage.ID, ErrUnmatchedPackage))
+			continue
+		}
+		if !j.c.sendTo(j.c.errorPort, informationPackage) {
+			return false
+		}
+	}
+	return true
+}
+
+func (j *Join) remove(key string) {
+	delete(j.pending, key)
+	for k, pendingKey := range j.order {
+		if pendingKey == key {
+			j.order = append(j.order[:k], j.order[k+1:]...)
+			return
+		}
+	}
+}
+
+func (j *Join) joined(entry *joinEntry) *InformationPackage {
+	return NewInformationPackage(
+		fmt.Sprintf("%s_%s", j.c.id, entry.key),
+		Joined{
+			CorrelationKey: entry.key,
+			Packages:       entry.packages,
+		},
+	)
+}
@@ -0,0 +1,136 @@
+package fbp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+func joinPorts(n int) (ports []*fbp.Port) {
+	for k := 0; k < n; k++ {
+		ports = append(ports, fbp.NewPort(fmt.Sprintf("join_in_%d", k), make(chan *fbp.InformationPackage), nil))
+	}
+	return
+}
+
+func numberKey(ip *fbp.InformationPackage) string {
+	return fmt.Sprint(numberOf(ip))
+}
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      fbp.JoinKind
+		want      []string
+		unmatched []string
+	}{
+		{name: "inner", kind: fbp.InnerJoin, want: []string{"join_1"}, unmatched: []string{"n2", "n3"}},
+		{name: "left", kind: fbp.LeftJoin, want: []string{"join_1", "join_2"}, unmatched: []string{"n3"}},
+		{name: "outer", kind: fbp.OuterJoin, want: []string{"join_1", "join_2", "join_3"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			inports := joinPorts(2)
+			join, err := fbp.NewJoin(inports, fbp.JoinSpec{Kind: tc.kind, Key: numberKey, MaxPending: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			errorPort := fbp.NewPort("errors", nil, make(chan *fbp.InformationPackage, 10))
+			component := fbp.NewComponentWith(ctx, "join", join, fbp.WithErrorPort(errorPort))
+			component.Stream()
+
+			// Closing the in ports closes the pending correlations
+			inports[0].In <- numberPackage(1)
+			inports[0].In <- numberPackage(2)
+			inports[1].In <- numberPackage(1)
+			inports[1].In <- numberPackage(3)
+			close(inports[0].In)
+			close(inports[1].In)
+			if err = component.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			got, unmatched := drainIDs(component.Port().Out), drainIDs(errorPort.Out)
+			sort.Strings(got)
+			sort.Strings(unmatched)
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if fmt.Sprint(unmatched) != fmt.Sprint(tc.unmatched) {
+				t.Errorf("got unmatched %v, want %v", unmatched, tc.unmatched)
+			}
+		})
+	}
+}
+
+func TestJoinTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := fbp.NewFakeClock(time.Now())
+	errorHandler, errs := errorRecorder()
+	inports := joinPorts(2)
+	join, err := fbp.NewJoin(inports, fbp.JoinSpec{Kind: fbp.InnerJoin, Key: numberKey, MaxPending: 10, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	component := fbp.NewComponentWith(ctx, "join", join, fbp.WithClock(clock), fbp.WithErrorHandler(errorHandler))
+	component.Stream()
+	clock.BlockUntil(1)
+
+	// The third package is read once the join has added the first one, which
+	// times out. The others time out too, or they're closed with the in ports
+	inports[0].In <- numberPackage(1)
+	inports[0].In <- numberPackage(2)
+	inports[0].In <- numberPackage(3)
+	clock.Advance(3 * time.Second)
+	for deadline := time.Now().Add(5 * time.Second); len(errs()) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("package not timed out")
+		}
+	}
+
+	// The match arrives too late
+	inports[1].In <- numberPackage(1)
+	close(inports[0].In)
+	close(inports[1].In)
+	if err = component.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := drainIDs(component.Port().Out); len(got) > 0 {
+		t.Errorf("got %v, want no match", got)
+	}
+	for _, err := range errs() {
+		if !errors.Is(err, fbp.ErrUnmatchedPackage) {
+			t.Errorf("got error %v, want %v", err, fbp.ErrUnmatchedPackage)
+		}
+	}
+	if len(errs()) != 4 {
+		t.Errorf("got %d errors, want 4", len(errs()))
+	}
+}
+
+func TestNewJoinValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		inports []*fbp.Port
+		spec    fbp.JoinSpec
+	}{
+		{name: "one in port", inports: joinPorts(1), spec: fbp.JoinSpec{Key: numberKey, MaxPending: 1}},
+		{name: "no key", inports: joinPorts(2), spec: fbp.JoinSpec{MaxPending: 1}},
+		{name: "no max pending", inports: joinPorts(2), spec: fbp.JoinSpec{Key: numberKey}},
+	}
+	for _, tc := range tests {
+		if _, err := fbp.NewJoin(tc.inports, tc.spec); err == nil {
+			t.Errorf("%s: spec accepted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package fbp
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// Logger is the logging interface of the runtime. NewZapLogger,
+	// NewSlogLogger and NewNopLogger adapt the usual loggers to it
+	Logger interface {
+		Debug(msg string, fields ...Field)
+		Info(msg string, fields ...Field)
+		Warn(msg string, fields ...Field)
+		Error(msg string, fields ...Field)
+		// With returns a logger adding the fields to all its lines
+		With(fields ...Field) Logger
+	}
+
+	Field struct {
+		Key   string
+		Value interface{}
+	}
+
+	zapLogger struct {
+		logger *zap.Logger
+	}
+
+	nopLogger struct{}
+)
+
+func String(key string, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Uint64(key string, value uint64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err is the field of an error, with the "error" key
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+func NewZapLogger(logger *zap.Logger) Logger {
+	return zapLogger{logger: logger}
+}
+
+func zapFields(fields []Field) []zap.Field {
+	zf := make([]zap.Field, len(fields))
+	for k, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			zf[k] = zap.NamedError(f.Key, err)
+			continue
+		}
+		zf[k] = zap.Any(f.Key, f.Value)
+	}
+	return zf
+}
+
+func (l zapLogger) Debug(msg string, fields ...Field) {
+	l.logger.Debug(msg, zapFields(fields)...)
+}
+
+func (l zapLogger) Info(msg string, fields ...Field) {
+	l.logger.Info(msg, zapFields(fields)...)
+}
+
+func (l zapLogger) Warn(msg string, fields ...Field) {
+	l.logger.Warn(msg, zapFields(fields)...)
+}
+
+func (l zapLogger) Error(msg string, fields ...Field) {
+	l.logger.Error(msg, zapFields(fields)...)
+}
+
+func (l zapLogger) With(fields ...Field) Logger {
+	return zapLogger{logger: l.logger.With(zapFields(fields)...)}
+}
+
+// NewNopLogger returns a logger discarding everything
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, fields ...Field) {}
+
+func (nopLogger) Info(msg string, fields ...Field) {}
+
+func (nopLogger) Warn(msg string, fields ...Field) {}
+
+func (nopLogger) Error(msg string, fields ...Field) {}
+
+func (l nopLogger) With(fields ...Field) Logger {
+	return l
+}
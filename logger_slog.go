@@ -0,0 +1,45 @@
+//go:build go1.21
+// +build go1.21
+
+package fbp
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func slogArgs(fields []Field) []interface{} {
+	args := make([]interface{}, len(fields))
+	for k, f := range fields {
+		args[k] = slog.Any(f.Key, f.Value)
+	}
+	return args
+}
+
+func (l slogLogger) Debug(msg string, fields ...Field) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, slogArgs(fields)...)
+}
+
+func (l slogLogger) Info(msg string, fields ...Field) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, slogArgs(fields)...)
+}
+
+func (l slogLogger) Warn(msg string, fields ...Field) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, slogArgs(fields)...)
+}
+
+func (l slogLogger) Error(msg string, fields ...Field) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, slogArgs(fields)...)
+}
+
+func (l slogLogger) With(fields ...Field) Logger {
+	return slogLogger{logger: l.logger.With(slogArgs(fields)...)}
+}
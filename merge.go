@@ -0,0 +1,256 @@
+package fbp
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+type (
+	// MergeStrategy decides the order in which StreamMerge reads its inputs
+	// and emits their packages. StreamFanIn is the plain arrival order merge
+	MergeStrategy interface {
+		// Inputs returns the inputs in preference order. When strict, only
+		// the first available one is read, waiting for it if needed
+		Inputs(n int) (order []int, strict bool)
+		// Push receives a package read from the input k, and returns the
+		// packages ready to be emitted
+		Push(k int, informationPackage *InformationPackage) []*InformationPackage
+		// Flush returns all the packages held back. It's called before
+		// forwarding a checkpoint barrier, and when all the inputs are closed
+		Flush() []*InformationPackage
+	}
+
+	orderedMerge struct {
+		window  int
+		next    uint64
+		pending map[uint64]*InformationPackage
+	}
+
+	priorityMerge struct{}
+
+	roundRobinMerge struct {
+		k int
+	}
+)
+
+// NewOrderedMerge emits the packages in the sequence order assigned by
+// StreamDispatch, holding back the ones arriving before their turn. The
+// packages dropped by the tasks would leave a gap forever, so when more
+// than window packages are held back the merge skips to the lowest one
+func NewOrderedMerge(window int) MergeStrategy {
+	if window < 1 {
+		window = 1
+	}
+	return &orderedMerge{
+		window:  window,
+		next:    1,
+		pending: make(map[uint64]*InformationPackage),
+	}
+}
+
+func (om *orderedMerge) Inputs(n int) (order []int, strict bool) {
+	return sequence(0, n), false
+}
+
+func (om *orderedMerge) Push(k int, informationPackage *InformationPackage) (ready []*InformationPackage) {
+	// Unnumbered packages, and the ones whose turn was skipped, can't be ordered
+	if informationPackage.Seq < om.next {
+		return []*InformationPackage{informationPackage}
+	}
+	om.pending[informationPackage.Seq] = informationPackage
+	for {
+		for {
+			next, ok := om.pending[om.next]
+			if !ok {
+				break
+			}
+			ready = append(ready, next)
+			delete(om.pending, om.next)
+			om.next++
+		}
+		if len(om.pending) <= om.window {
+			return
+		}
+		om.next = om.lowest()
+	}
+}
+
+func (om *orderedMerge) lowest() (lowest uint64) {
+	for seq := range om.pending {
+		if lowest == 0 || seq < lowest {
+			lowest = seq
+		}
+	}
+	return
+}
+
+func (om *orderedMerge) Flush() (ready []*InformationPackage) {
+	for seq, informationPackage := range om.pending {
+		ready = append(ready, informationPackage)
+		if seq >= om.next {
+			om.next = seq + 1
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return ready[i].Seq < ready[j].Seq })
+	om.pending = make(map[uint64]*InformationPackage)
+	return
+}
+
+// NewPriorityMerge always emits the packages waiting in the first inputs
+// before the ones waiting in the later inputs
+func NewPriorityMerge() MergeStrategy {
+	return &priorityMerge{}
+}
+
+func (pm *priorityMerge) Inputs(n int) (order []int, strict bool) {
+	return sequence(0, n), false
+}
+
+func (pm *priorityMerge) Push(k int, informationPackage *InformationPackage) []*InformationPackage {
+	return []*InformationPackage{informationPackage}
+}
+
+func (pm *priorityMerge) Flush() []*InformationPackage {
+	return nil
+}
+
+// NewRoundRobinMerge takes one package from each input in turn, waiting for
+// the input whose turn it is. The closed inputs lose their turn
+func NewRoundRobinMerge() MergeStrategy {
+	return &roundRobinMerge{}
+}
+
+func (rr *roundRobinMerge) Inputs(n int) (order []int, strict bool) {
+	return sequence(rr.k%n, n), true
+}
+
+func (rr *roundRobinMerge) Push(k int, informationPackage *InformationPackage) []*InformationPackage {
+	rr.k = k + 1
+	return []*InformationPackage{informationPackage}
+}
+
+func (rr *roundRobinMerge) Flush() []*InformationPackage {
+	return nil
+}
+
+// sequence returns the n indexes starting from start, wrapping around
+func sequence(start int, n int) []int {
+	order := make([]int, n)
+	for k := range order {
+		order[k] = (start + k) % n
+	}
+	return order
+}
+
+// StreamMerge merges the packages of the from ports into the to port in the
+// order set by the strategy. Checkpoint barriers are aligned: an input that
+// delivers a barrier is not read again until all of them have delivered it
+func (c *Connection) StreamMerge(from []Port, to *Port, strategy MergeStrategy) (err error) {
+	if len(from) == 0 {
+		return errors.New("to stream a merge connection, at least one in port is required")
+	}
+	go func() {
+		c.logger.Info("starting merge connection", Int("in", len(from)))
+		c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID})
+
+		closed := make([]bool, len(from))
+		blocked := make([]bool, len(from))
+		var barrier *InformationPackage
+
+		emit := func(k int, packages []*InformationPackage) bool {
+			for _, informationPackage := range packages {
+				if !c.send(&from[k], to, informationPackage) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// releaseBarrier forwards the barrier once all the open inputs have delivered it
+		releaseBarrier := func(k int) bool {
+			if barrier == nil {
+				return true
+			}
+			for z := range from {
+				if !closed[z] && !blocked[z] {
+					return true
+				}
+			}
+			if !emit(k, strategy.Flush()) || !c.send(&from[k], to, barrier) {
+				return false
+			}
+			barrier = nil
+			blocked = make([]bool, len(from))
+			return true
+		}
+
+		for {
+			var available []int
+			order, strict := strategy.Inputs(len(from))
+			for _, k := range order {
+				if !closed[k] && !blocked[k] {
+					available = append(available, k)
+				}
+			}
+			if len(available) == 0 {
+				emit(0, strategy.Flush())
+				return
+			}
+			if strict {
+				available = available[:1]
+			}
+
+			k, informationPackage, ok, done := c.receive(from, available)
+			if done {
+				return
+			}
+			if !ok {
+				closed[k] = true
+				if !releaseBarrier(k) {
+					return
+				}
+				continue
+			}
+			if informationPackage.IsBarrier() {
+				barrier = informationPackage
+				blocked[k] = true
+				if !releaseBarrier(k) {
+					return
+				}
+				continue
+			}
+			if !emit(k, strategy.Push(k, informationPackage)) {
+				return
+			}
+		}
+	}()
+	return
+}
+
+// receive reads from the first input in the list that has a package ready,
+// or waits for any of them if none has
+func (c *Connection) receive(from []Port, inputs []int) (k int, informationPackage *InformationPackage, ok bool, done bool) {
+	for _, k = range inputs {
+		select {
+		case informationPackage, ok = <-from[k].Out:
+			return
+		default:
+		}
+	}
+
+	cases := make([]reflect.SelectCase, len(inputs)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ctx.Done())}
+	for z, k := range inputs {
+		cases[z+1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(from[k].Out)}
+	}
+	chosen, value, ok := reflect.Select(cases)
+	if chosen == 0 {
+		return 0, nil, false, true
+	}
+	k = inputs[chosen-1]
+	if ok {
+		informationPackage = value.Interface().(*InformationPackage)
+	}
+	return
+}
@@ -0,0 +1,704 @@
+package fbp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrNetworkRunning         = errors.New("network is running")
+	ErrNetworkNotRunning      = errors.New("network is not running")
+	ErrNodeAlreadyExists      = errors.New("node already exists")
+	ErrNodeDoesNotExist       = errors.New("node does not exist")
+	ErrEdgeAlreadyExists      = errors.New("edge already exists")
+	ErrEdgeDoesNotExist       = errors.New("edge does not exist")
+	ErrConnectionDoesNotExist = errors.New("connection does not exist")
+)
+
+type (
+	// Node is a process of the network: an instance of a registered component
+	Node struct {
+		ID        string
+		Component string
+		port      *Port
+		component *Component
+		inputs    int
+		// removed is closed when the node is removed from the running network
+		removed chan struct{}
+	}
+
+	// Edge connects the out port of the From node with the in port of the To node
+	Edge struct {
+		From string
+		To   string
+	}
+
+	networkTap struct {
+		connection string
+		tap        *edgeTap
+	}
+
+	initialPackage struct {
+		node               string
+		informationPackage *InformationPackage
+	}
+
+	// Network is a graph of nodes and edges built from the components of a
+	// registry, that can be started, stopped and edited either way
+	Network struct {
+		ID           string
+		ctx          context.Context
+		registry     *Registry
+		errorHandler *ErrorHandler
+		logger       Logger
+		checkpointer *Checkpointer
+		clock        Clock
+		events       *EventBus
+		buffer       int
+		shuffle      *shuffler
+
+		mux      sync.RWMutex
+		nodes    map[string]*Node
+		edges    []Edge
+		initials []initialPackage
+		inports  map[string]string
+		outports map[string]string
+		conns    map[string]*Connection
+		taps     map[int]networkTap
+		nextTap  int
+		runCtx   context.Context
+		cancel   context.CancelFunc
+
+		obsMux    sync.RWMutex
+		observers []func(edge Edge, informationPackage *InformationPackage)
+	}
+)
+
+func (e Edge) ID() string {
+	return fmt.Sprintf("%s() OUT -> IN %s()", e.From, e.To)
+}
+
+func NewNetwork(ctx context.Context, id string, registry *Registry, errorHandler *ErrorHandler, logger Logger) *Network {
+	return NewNetworkWith(ctx, id, registry, WithErrorHandler(errorHandler), WithLogger(logger))
+}
+
+// AddNode adds a node. If the network is running, the node starts right away
+func (n *Network) AddNode(id string, component string) (err error) {
+	if _, err = n.registry.Get(component); err != nil {
+		return
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if _, ok := n.nodes[id]; ok {
+		return ErrNodeAlreadyExists
+	}
+	// A node being removed exists until it's drained
+	if _, ok := n.conns[id]; ok {
+		return ErrNodeAlreadyExists
+	}
+	node := &Node{
+		ID:        id,
+		Component: component,
+	}
+	if n.cancel != nil {
+		task, err := n.task(node)
+		if err != nil {
+			return err
+		}
+		n.run(node, task).Stream()
+		n.nodes[id] = node
+		n.connect(id)
+		return nil
+	}
+	n.nodes[id] = node
+	return
+}
+
+// RemoveNode removes a node along with its edges. If the network is running,
+// the nodes connected to it stop sending to it, and it's drained before
+// returning: it processes the packages it has already received, and its
+// output still reaches the nodes it was connected to. If the network stops
+// meanwhile, the node stops with it and is removed all the same
+func (n *Network) RemoveNode(id string) (err error) {
+	n.mux.Lock()
+	node, ok := n.nodes[id]
+	if !ok {
+		n.mux.Unlock()
+		return ErrNodeDoesNotExist
+	}
+	delete(n.nodes, id)
+	checkpointer := n.checkpointer
+
+	edges := n.edges[:0]
+	for _, edge := range n.edges {
+		if edge.From != id && edge.To != id {
+			edges = append(edges, edge)
+		}
+	}
+	n.edges = edges
+	n.removeInitials(id)
+	for public, node := range n.inports {
+		if node == id {
+			delete(n.inports, public)
+		}
+	}
+	for public, node := range n.outports {
+		if node == id {
+			delete(n.outports, public)
+		}
+	}
+
+	// The connection of the node stays until the node is drained, so it
+	// can still be tapped, but it's left out of the rewiring, so it goes on
+	// forwarding to the nodes it was connected to
+	var (
+		ctx  context.Context
+		conn *Connection
+	)
+	if n.cancel != nil {
+		ctx, conn = n.runCtx, n.conns[id]
+		close(node.removed)
+		n.rewire()
+	}
+	n.mux.Unlock()
+
+	if ctx != nil {
+		// Draining only fails when the network stops meanwhile, which stops
+		// the node as well, so it's removed anyway
+		node.component.Drain(ctx)
+		n.mux.Lock()
+		if conn != nil && n.conns[id] == conn {
+			for _, t := range n.taps {
+				if t.connection == id {
+					conn.detach(t.tap)
+				}
+			}
+			delete(n.conns, id)
+		}
+		n.mux.Unlock()
+	}
+	if checkpointer != nil {
+		checkpointer.Unregister(id)
+	}
+	return
+}
+
+// AddEdge connects two nodes. If the network is running, the from node
+// starts sending to the to node right away
+func (n *Network) AddEdge(from string, to string) (err error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if _, ok := n.nodes[from]; !ok {
+		return ErrNodeDoesNotExist
+	}
+	if _, ok := n.nodes[to]; !ok {
+		return ErrNodeDoesNotExist
+	}
+	edge := Edge{From: from, To: to}
+	if n.edgeIndex(edge) >= 0 {
+		return ErrEdgeAlreadyExists
+	}
+	n.edges = append(n.edges, edge)
+	if n.cancel != nil {
+		n.rewire()
+	}
+	return
+}
+
+// RemoveEdge disconnects two nodes. If the network is running, the from node
+// stops sending to the to node right away: a package waiting for the to node
+// to accept it goes to the other nodes the from node is connected to
+func (n *Network) RemoveEdge(from string, to string) (err error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	k := n.edgeIndex(Edge{From: from, To: to})
+	if k < 0 {
+		return ErrEdgeDoesNotExist
+	}
+	n.edges = append(n.edges[:k], n.edges[k+1:]...)
+	if n.cancel != nil {
+		n.rewire()
+	}
+	return
+}
+
+func (n *Network) edgeIndex(edge Edge) int {
+	for k, e := range n.edges {
+		if e == edge {
+			return k
+		}
+	}
+	return -1
+}
+
+// AddInitial adds an initial information package, sent to the node each time
+// the network starts. If the network is already running, it's also sent right away
+func (n *Network) AddInitial(node string, informationPackage *InformationPackage) (err error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	target, ok := n.nodes[node]
+	if !ok {
+		return ErrNodeDoesNotExist
+	}
+	n.initials = append(n.initials, initialPackage{node: node, informationPackage: informationPackage})
+	if n.cancel != nil {
+		go n.deliver(n.runCtx, target, informationPackage)
+	}
+	return
+}
+
+func (n *Network) RemoveInitials(node string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.removeInitials(node)
+}
+
+func (n *Network) removeInitials(node string) {
+	initials := n.initials[:0]
+	for _, initial := range n.initials {
+		if initial.node != node {
+			initials = append(initials, initial)
+		}
+	}
+	n.initials = initials
+}
+
+// AddInport exposes the in port of a node with a public name
+func (n *Network) AddInport(public string, node string) (err error) {
+	return n.addExported(n.inports, public, node)
+}
+
+// AddOutport exposes the out port of a node with a public name. The packages
+// sent by the node are not discarded anymore, and must be read from Outport
+func (n *Network) AddOutport(public string, node string) (err error) {
+	return n.addExported(n.outports, public, node)
+}
+
+func (n *Network) RemoveInport(public string) {
+	n.removeExported(n.inports, public)
+}
+
+func (n *Network) RemoveOutport(public string) {
+	n.removeExported(n.outports, public)
+}
+
+func (n *Network) addExported(ports map[string]string, public string, node string) (err error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.cancel != nil {
+		return ErrNetworkRunning
+	}
+	if _, ok := n.nodes[node]; !ok {
+		return ErrNodeDoesNotExist
+	}
+	ports[public] = node
+	return
+}
+
+func (n *Network) removeExported(ports map[string]string, public string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	delete(ports, public)
+}
+
+// Send delivers an information package to the node exposed as the public in port
+func (n *Network) Send(public string, informationPackage *InformationPackage) (err error) {
+	n.mux.RLock()
+	node, ok := n.inports[public]
+	if !ok {
+		n.mux.RUnlock()
+		return fmt.Errorf("in port %s is not exposed", public)
+	}
+	if n.cancel == nil {
+		n.mux.RUnlock()
+		return ErrNetworkNotRunning
+	}
+	ctx, target := n.runCtx, n.nodes[node]
+	n.mux.RUnlock()
+
+	return n.deliver(ctx, target, informationPackage)
+}
+
+// Outport returns the channel where the node exposed as the public out port
+// sends its packages. The network must be running
+func (n *Network) Outport(public string) (out chan *InformationPackage, err error) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	node, ok := n.outports[public]
+	if !ok {
+		return nil, fmt.Errorf("out port %s is not exposed", public)
+	}
+	if n.cancel == nil {
+		return nil, ErrNetworkNotRunning
+	}
+	out = n.nodes[node].port.Out
+	return
+}
+
+// deliver sends a package to a node of the running network, giving up if the
+// network stops or the node is removed meanwhile
+func (n *Network) deliver(ctx context.Context, node *Node, informationPackage *InformationPackage) (err error) {
+	select {
+	case <-ctx.Done():
+		return ErrNetworkNotRunning
+	case <-node.removed:
+		return ErrNodeDoesNotExist
+	case node.port.In <- informationPackage:
+		return nil
+	}
+}
+
+func deliver(ctx context.Context, port *Port, informationPackage *InformationPackage) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case port.In <- informationPackage:
+		return true
+	}
+}
+
+// OnPackage registers a function called with every package crossing an edge
+func (n *Network) OnPackage(f func(edge Edge, informationPackage *InformationPackage)) {
+	n.obsMux.Lock()
+	defer n.obsMux.Unlock()
+
+	n.observers = append(n.observers, f)
+}
+
+// notify has its own lock, as the connections call it while the network lock
+// can be held waiting for them to change their destinations
+func (n *Network) notify(edge Edge, informationPackage *InformationPackage) {
+	n.obsMux.RLock()
+	observers := n.observers
+	n.obsMux.RUnlock()
+
+	for _, observer := range observers {
+		observer(edge, informationPackage)
+	}
+}
+
+func (n *Network) Nodes() (nodes []Node) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	for _, node := range n.nodes {
+		nodes = append(nodes, Node{ID: node.ID, Component: node.Component})
+	}
+	return
+}
+
+func (n *Network) Edges() (edges []Edge) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	return append(edges, n.edges...)
+}
+
+func (n *Network) Running() bool {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	return n.cancel != nil
+}
+
+// Start instantiates a component for each node and a connection for each edge
+func (n *Network) Start() (err error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.cancel != nil {
+		return ErrNetworkRunning
+	}
+
+	tasks := make(map[string]Task, len(n.nodes))
+	for id, node := range n.nodes {
+		task, err := n.task(node)
+		if err != nil {
+			return err
+		}
+		tasks[id] = task
+	}
+
+	ctx, cancel := context.WithCancel(n.ctx)
+	n.runCtx, n.cancel = ctx, cancel
+	n.logger.Info("network starting")
+
+	components := make([]*Component, 0, len(n.nodes))
+	for id, node := range n.nodes {
+		components = append(components, n.run(node, tasks[id]))
+	}
+	if n.checkpointer != nil {
+		if _, err = n.checkpointer.RestoreLatest(); err != nil && err != ErrNoCheckpoint {
+			cancel()
+			n.runCtx, n.cancel = nil, nil
+			return
+		}
+		err = nil
+	}
+	for _, component := range components {
+		component.Stream()
+	}
+
+	// Each node gets a single connection which distributes its packages
+	// round robin between the nodes it's connected to, or at random when
+	// the network is shuffled. The connections of the nodes connected to
+	// none discard their packages, so they don't block. The nodes exposed as
+	// out ports get one only if they are connected, as their packages must
+	// be read from Outport
+	exported := make(map[string]bool, len(n.outports))
+	for _, node := range n.outports {
+		exported[node] = true
+	}
+	n.conns = make(map[string]*Connection, len(n.nodes))
+	for id := range n.nodes {
+		if !exported[id] {
+			n.connect(id)
+		}
+	}
+	n.rewire()
+
+	for _, initial := range n.initials {
+		go n.deliver(ctx, n.nodes[initial.node], initial.informationPackage)
+	}
+	n.events.Publish(Event{Kind: NetworkStarted, Source: n.ID})
+	return
+}
+
+// task creates the task of a node, giving it the clock and the bus of the
+// network if it uses them
+func (n *Network) task(node *Node) (task Task, err error) {
+	spec, err := n.registry.Get(node.Component)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %s", node.ID, err)
+	}
+	task = spec.New()
+	if aware, ok := task.(ClockAware); ok {
+		aware.SetClock(n.clock)
+	}
+	if aware, ok := task.(EventAware); ok {
+		aware.SetEventBus(n.events)
+	}
+	return
+}
+
+// run creates the component of a node with a new port. It must be called
+// holding the lock while the network is running
+func (n *Network) run(node *Node, task Task) *Component {
+	node.port = NewPort(
+		node.ID,
+		make(chan *InformationPackage, n.buffer),
+		make(chan *InformationPackage, n.buffer),
+	)
+	node.inputs = 0
+	node.removed = make(chan struct{})
+	node.component = NewComponent(n.runCtx, node.ID, node.port, task, n.errorHandler, n.logger)
+	node.component.SetClock(n.clock)
+	node.component.SetEventBus(n.events)
+	node.component.shuffle = n.shuffle
+	if n.checkpointer != nil {
+		node.component.SetCheckpointer(n.checkpointer)
+	}
+	return node.component
+}
+
+// connect streams the connection of a node, without destinations until the
+// network is rewired. It must be called holding the lock while the network
+// is running
+func (n *Network) connect(from string) {
+	conn := NewConnection(n.runCtx, from, n.logger)
+	conn.SetEventBus(n.events)
+	conn.shuffle = n.shuffle
+	conn.clock = n.clock
+	conn.OnPackage(func(from *Port, to *Port, informationPackage *InformationPackage) {
+		n.notify(Edge{From: from.ID, To: to.ID}, informationPackage)
+	})
+	for _, t := range n.taps {
+		if t.connection == from {
+			conn.attach(t.tap)
+		}
+	}
+	var strategy DispatchStrategy = NewRoundRobin()
+	if n.shuffle != nil {
+		strategy = n.shuffle.strategy()
+	}
+	conn.streamDispatch(n.nodes[from].port, nil, strategy)
+	n.conns[from] = conn
+}
+
+// rewire brings the running network in line with its edges: it sets how
+// many inputs each node has, to align the checkpoint barriers, and where
+// each connection sends to. It must be called holding the lock. Changing the
+// edges while a checkpoint is in progress can make it fail
+func (n *Network) rewire() {
+	inputs := make(map[string]int)
+	targets := make(map[string][]string)
+	for _, edge := range n.edges {
+		inputs[edge.To]++
+		targets[edge.From] = append(targets[edge.From], edge.To)
+	}
+	for id, node := range n.nodes {
+		if node.inputs != inputs[id] {
+			node.inputs = inputs[id]
+			node.port.SetInputs(node.inputs)
+		}
+	}
+	for from := range targets {
+		if _, ok := n.conns[from]; !ok {
+			n.connect(from)
+		}
+	}
+	for from, conn := range n.conns {
+		if _, ok := n.nodes[from]; !ok {
+			// The node is being removed
+			continue
+		}
+		to := make([]Port, len(targets[from]))
+		for k, id := range targets[from] {
+			to[k] = *n.nodes[id].port
+		}
+		conn.SetDestinations(to)
+	}
+}
+
+// Record makes the recorder record the packages of a connection of the
+// running network. Each node has a single connection to the nodes its out
+// port is connected to, with the node ID as its ID. A nil recorder stops
+// recording. The recording ends when the network stops
+func (n *Network) Record(connection string, recorder *Recorder) (err error) {
+	conn, err := n.connection(connection)
+	if err != nil {
+		return
+	}
+	conn.Record(recorder)
+	return
+}
+
+// Subscribe returns a channel receiving one of every every packages crossing
+// a connection, and the function to unsubscribe, which closes the channel.
+// Each node has a single connection to the nodes its out port is connected
+// to, with the node ID as its ID. The subscription lasts across the network
+// restarts, so it can be made before the connection exists. See
+// Connection.Subscribe
+func (n *Network) Subscribe(connection string, buffer int, every int) (records <-chan Record, unsubscribe func()) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	id := n.nextTap
+	n.nextTap++
+	t := newEdgeTap(buffer, every)
+	n.taps[id] = networkTap{connection: connection, tap: t}
+	if conn, ok := n.conns[connection]; ok {
+		conn.attach(t)
+	}
+
+	var once sync.Once
+	return t.records, func() {
+		once.Do(func() {
+			n.mux.Lock()
+			defer n.mux.Unlock()
+
+			delete(n.taps, id)
+			if conn, ok := n.conns[connection]; ok {
+				conn.detach(t)
+			}
+			close(t.records)
+		})
+	}
+}
+
+func (n *Network) connection(id string) (*Connection, error) {
+	n.mux.RLock()
+	defer n.mux.RUnlock()
+
+	if n.cancel == nil {
+		return nil, ErrNetworkNotRunning
+	}
+	conn, ok := n.conns[id]
+	if !ok {
+		return nil, ErrConnectionDoesNotExist
+	}
+	return conn, nil
+}
+
+// SetCheckpointer enables the checkpoints of the network. The state of the
+// stateful nodes is restored from the latest checkpoint each time the network starts
+func (n *Network) SetCheckpointer(checkpointer *Checkpointer) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.checkpointer = checkpointer
+}
+
+// SetClock sets the clock given to the components and the ClockAware tasks
+// of the nodes when the network starts
+func (n *Network) SetClock(clock Clock) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	n.clock = clock
+	n.events.SetClock(clock)
+}
+
+// Events returns the bus where the network, its components, its connections
+// and its EventAware tasks publish their events
+func (n *Network) Events() *EventBus {
+	return n.events
+}
+
+// Checkpoint takes a consistent checkpoint of the running network, injecting
+// the barrier at the nodes that have not any incoming edge
+func (n *Network) Checkpoint(ctx context.Context) (id int64, err error) {
+	n.mux.RLock()
+	if n.cancel == nil {
+		n.mux.RUnlock()
+		return 0, ErrNetworkNotRunning
+	}
+	if n.checkpointer == nil {
+		n.mux.RUnlock()
+		return 0, errors.New("network has not any checkpointer")
+	}
+	checkpointer := n.checkpointer
+	inputs := make(map[string]bool)
+	for _, edge := range n.edges {
+		inputs[edge.To] = true
+	}
+	var sources []*Port
+	for id, node := range n.nodes {
+		if !inputs[id] {
+			sources = append(sources, node.port)
+		}
+	}
+	n.mux.RUnlock()
+
+	return checkpointer.Trigger(ctx, sources...)
+}
+
+func (n *Network) Stop() (err error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if n.cancel == nil {
+		return ErrNetworkNotRunning
+	}
+	n.cancel()
+	for _, t := range n.taps {
+		if conn, ok := n.conns[t.connection]; ok {
+			conn.detach(t.tap)
+		}
+	}
+	n.runCtx, n.cancel, n.conns = nil, nil, nil
+	n.logger.Info("network stopped")
+	n.events.Publish(Event{Kind: NetworkStopped, Source: n.ID})
+	return
+}
@@ -0,0 +1,70 @@
+package fbp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+func TestRemoveNodeWhileStopping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The node doesn't end its task until the network stops, so it can't
+	// be drained
+	started := make(chan struct{}, 1)
+	registry := fbp.NewRegistry()
+	err := registry.Register(fbp.ComponentSpec{Name: "blocking", New: func() fbp.Task {
+		return fbp.ContextTaskFunc(func(ctx context.Context, in *fbp.InformationPackage) (*fbp.InformationPackage, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := fbp.NewNetworkWith(ctx, "network", registry)
+	if err = network.AddNode("blocking", "blocking"); err != nil {
+		t.Fatal(err)
+	}
+	if err = network.AddInport("in", "blocking"); err != nil {
+		t.Fatal(err)
+	}
+	if err = network.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err = network.Send("in", fbp.NewInformationPackage("ip", nil)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	removed := make(chan error, 1)
+	go func() {
+		removed <- network.RemoveNode("blocking")
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(network.Nodes()) > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("node not removed")
+		}
+	}
+
+	// The node exists until it's drained
+	if err = network.AddNode("blocking", "blocking"); err != fbp.ErrNodeAlreadyExists {
+		t.Errorf("got %v adding the node being removed, want %v", err, fbp.ErrNodeAlreadyExists)
+	}
+
+	if err = network.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-removed:
+		if err != nil {
+			t.Errorf("got %v removing the node, want it removed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("node removal not ended by the network stop")
+	}
+}
@@ -0,0 +1,217 @@
+package fbp
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultBuffer is the size of the channels of the ports created by the
+// constructors taking options
+const DefaultBuffer = 100
+
+type (
+	// Option configures the components, connections and networks created
+	// with NewComponentWith, NewConnectionWith and NewNetworkWith. Each of
+	// them ignores the options that don't apply to it
+	Option func(o *options)
+
+	options struct {
+		logger       Logger
+		errorHandler *ErrorHandler
+		errorPolicy  ErrorPolicy
+		buffer       int
+		concurrency  int
+		timeout      time.Duration
+		retryPolicy  *RetryPolicy
+		errorPort    *Port
+		port         *Port
+		clock        Clock
+		events       *EventBus
+		checkpointer *Checkpointer
+		shuffle      *shuffler
+	}
+)
+
+func newOptions(opts []Option) options {
+	o := options{
+		buffer:      DefaultBuffer,
+		concurrency: 1,
+		clock:       RealClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = NewNopLogger()
+	}
+	if o.errorHandler == nil {
+		o.errorHandler = NewErrorHandler(o.logger)
+	}
+	return o
+}
+
+// WithLogger sets the logger. By default nothing is logged
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithErrorHandler sets the error handler. By default the errors are logged
+// with the logger
+func WithErrorHandler(errorHandler *ErrorHandler) Option {
+	return func(o *options) {
+		o.errorHandler = errorHandler
+	}
+}
+
+// WithErrorPolicy sets what a component does after a task error. By default
+// it's ForwardOnError
+func WithErrorPolicy(policy ErrorPolicy) Option {
+	return func(o *options) {
+		o.errorPolicy = policy
+	}
+}
+
+// WithBuffer sets the size of the channels of the ports created by the
+// constructors. By default it's DefaultBuffer
+func WithBuffer(size int) Option {
+	return func(o *options) {
+		o.buffer = size
+	}
+}
+
+// WithConcurrency sets how many packages a component processes at the same
+// time. By default it's one, which keeps the packages in order
+func WithConcurrency(workers int) Option {
+	return func(o *options) {
+		o.concurrency = workers
+	}
+}
+
+// WithTimeout bounds how long a component task can take for each package.
+// By default there's no timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetryPolicy makes a component retry its task when it fails
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithErrorPort sets the dead letter port of a component
+func WithErrorPort(port *Port) Option {
+	return func(o *options) {
+		o.errorPort = port
+	}
+}
+
+// WithPort makes a component use the port, instead of creating its own
+func WithPort(port *Port) Option {
+	return func(o *options) {
+		o.port = port
+	}
+}
+
+// WithClock sets the clock. By default it's the wall clock
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithEventBus sets the bus the events are published to. By default a
+// network creates its own, and components and connections publish none
+func WithEventBus(bus *EventBus) Option {
+	return func(o *options) {
+		o.events = bus
+	}
+}
+
+// WithCheckpointer enables the checkpoints
+func WithCheckpointer(checkpointer *Checkpointer) Option {
+	return func(o *options) {
+		o.checkpointer = checkpointer
+	}
+}
+
+// NewComponentWith creates a component with the options. Unless WithPort is
+// given, it creates its port, with the component id as its ID
+func NewComponentWith(ctx context.Context, id string, task Task, opts ...Option) *Component {
+	o := newOptions(opts)
+	port := o.port
+	if port == nil {
+		port = NewPort(id, make(chan *InformationPackage, o.buffer), make(chan *InformationPackage, o.buffer))
+	}
+	concurrency := o.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	c := &Component{
+		ctx:          ctx,
+		id:           id,
+		port:         port,
+		task:         task,
+		errorHandler: o.errorHandler,
+		logger:       o.logger.With(String("component_id", id), String("port_id", port.ID)),
+		clock:        o.clock,
+		concurrency:  concurrency,
+		timeout:      o.timeout,
+		errorPolicy:  o.errorPolicy,
+		retryPolicy:  o.retryPolicy,
+		errorPort:    o.errorPort,
+		events:       o.events,
+		shuffle:      o.shuffle,
+		drain:        make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if o.checkpointer != nil {
+		c.SetCheckpointer(o.checkpointer)
+	}
+	return c
+}
+
+// NewConnectionWith creates a connection with the options
+func NewConnectionWith(ctx context.Context, id string, opts ...Option) *Connection {
+	o := newOptions(opts)
+	return &Connection{
+		ctx:     ctx,
+		ID:      id,
+		logger:  o.logger.With(String("connection_id", id)),
+		events:  o.events,
+		shuffle: o.shuffle,
+		clock:   o.clock,
+	}
+}
+
+// NewNetworkWith creates a network with the options. WithBuffer sets the
+// size of the channels of its nodes ports
+func NewNetworkWith(ctx context.Context, id string, registry *Registry, opts ...Option) *Network {
+	o := newOptions(opts)
+	events := o.events
+	if events == nil {
+		events = NewEventBus()
+	}
+	events.SetClock(o.clock)
+	return &Network{
+		ID:           id,
+		ctx:          ctx,
+		registry:     registry,
+		errorHandler: o.errorHandler,
+		logger:       o.logger.With(String("network_id", id)),
+		checkpointer: o.checkpointer,
+		clock:        o.clock,
+		events:       events,
+		buffer:       o.buffer,
+		shuffle:      o.shuffle,
+		nodes:        make(map[string]*Node),
+		inports:      make(map[string]string),
+		outports:     make(map[string]string),
+		taps:         make(map[int]networkTap),
+	}
+}
@@ -4,14 +4,27 @@ type PortType int
 
 func NewPort(ID string, in chan *InformationPackage, out chan *InformationPackage) *Port {
 	return &Port{
-		ID:  ID,
-		In:  in,
-		Out: out,
+		ID:      ID,
+		In:      in,
+		Out:     out,
+		aligner: newBarrierAligner(1),
 	}
 }
 
 type Port struct {
-	ID  string
-	In  chan *InformationPackage
-	Out chan *InformationPackage
+	ID      string
+	In      chan *InformationPackage
+	Out     chan *InformationPackage
+	aligner *barrierAligner
+}
+
+// SetInputs sets how many connections feed the in port, so the checkpoint
+// barriers get aligned before reaching it. It can be changed while streaming
+// on the ports created with NewPort, but a checkpoint in progress may fail
+func (p *Port) SetInputs(inputs int) {
+	if p.aligner == nil {
+		p.aligner = newBarrierAligner(inputs)
+		return
+	}
+	p.aligner.setInputs(inputs)
 }
@@ -0,0 +1,298 @@
+package fbp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// LimitDelay holds the excess packages until the bucket has tokens again
+	LimitDelay LimitMode = iota
+	// LimitDrop discards the excess packages, handling them as errors
+	LimitDrop
+)
+
+const (
+	rateLimiterCleanupEvery = 1024
+
+	// DefaultMaxDelayed is how many packages a per key rate limiter holds
+	// by default in the LimitDelay mode
+	DefaultMaxDelayed = 1024
+)
+
+var ErrRateLimited = errors.New("package dropped by rate limit")
+
+type (
+	LimitMode int
+
+	// TokenBucket refills Rate tokens per second up to Burst tokens. It's
+	// driven by the times given to it, so it doesn't depend on the wall clock
+	TokenBucket struct {
+		rate   float64
+		burst  float64
+		mux    sync.Mutex
+		tokens float64
+		last   time.Time
+	}
+
+	RateLimitSpec struct {
+		// Rate is the packages per second let through, and Burst how many
+		// of them can go through at once
+		Rate  float64
+		Burst int
+		Mode  LimitMode
+		// Key gives each key its own limit. Optional
+		Key func(ip *InformationPackage) string
+		// MaxDelayed bounds the packages held by the LimitDelay mode with a
+		// Key, so the keys over their limit don't hold back the others. When
+		// it's reached, no more packages are read. By default DefaultMaxDelayed
+		MaxDelayed int
+	}
+
+	// delayedPackage is a package held by a per key rate limiter until at
+	delayedPackage struct {
+		at                 time.Time
+		informationPackage *InformationPackage
+	}
+
+	// RateLimiter is the task of a component that lets the packages it
+	// receives go through at the spec rate, measured with the component
+	// clock. A barrier goes out behind all the packages received before it,
+	// so no package is held across a checkpoint and the rate limiter has no
+	// state to save
+	RateLimiter struct {
+		streamer
+		spec RateLimitSpec
+
+		c       *Component
+		buckets map[string]*TokenBucket
+		seen    int
+	}
+)
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	if !tb.last.IsZero() && now.After(tb.last) {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+	}
+	if now.After(tb.last) {
+		tb.last = now
+	}
+}
+
+// Allow takes a token if there is any available at now
+func (tb *TokenBucket) Allow(now time.Time) bool {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	tb.refill(now)
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// Reserve takes a token, returning how long from now the caller must wait
+// before using it
+func (tb *TokenBucket) Reserve(now time.Time) (wait time.Duration) {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	tb.refill(now)
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// full reports whether the bucket would be full at now, so it's equivalent
+// to a brand new one
+func (tb *TokenBucket) full(now time.Time) bool {
+	tb.mux.Lock()
+	defer tb.mux.Unlock()
+
+	tb.refill(now)
+	return tb.tokens >= tb.burst
+}
+
+func NewRateLimiter(spec RateLimitSpec) (rl *RateLimiter, err error) {
+	if spec.Rate <= 0 || spec.Burst < 1 {
+		return nil, errors.New("rate limiter requires a positive rate and a burst of at least one")
+	}
+	rl = &RateLimiter{
+		spec:    spec,
+		buckets: make(map[string]*TokenBucket),
+	}
+	return
+}
+
+func (rl *RateLimiter) stream(c *Component) {
+	rl.c = c
+	c.logger.Info("rate limiter starting", Float64("rate", rl.spec.Rate), Int("burst", rl.spec.Burst))
+	if rl.spec.Mode == LimitDelay && rl.spec.Key != nil {
+		rl.streamKeyed()
+		return
+	}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case informationPackage, ok := <-c.port.In:
+			if !ok {
+				c.logger.Warn("in port closed")
+				return
+			}
+			if !informationPackage.IsBarrier() && !rl.limit(informationPackage) {
+				continue
+			}
+			if !c.send(informationPackage) {
+				return
+			}
+		}
+	}
+}
+
+// limit waits for the package turn, or reports it must be dropped
+func (rl *RateLimiter) limit(informationPackage *InformationPackage) bool {
+	var key string
+	if rl.spec.Key != nil {
+		key = rl.spec.Key(informationPackage)
+	}
+	now := rl.c.clock.Now()
+	bucket := rl.bucket(key, now)
+
+	if rl.spec.Mode == LimitDrop {
+		if bucket.Allow(now) {
+			return true
+		}
+		err := fmt.Errorf("rate limiter %s, key %q, package %s: %w", rl.c.id, key, informationPackage.ID, ErrRateLimited)
+		rl.c.errorHandler.Handle(err)
+		rl.c.events.Publish(Event{Kind: PackageDropped, Source: rl.c.id, Port: rl.c.port.ID, PackageID: informationPackage.ID, Err: err})
+		return false
+	}
+
+	wait := bucket.Reserve(now)
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-rl.c.ctx.Done():
+		return false
+	case <-rl.c.clock.After(wait):
+		return true
+	}
+}
+
+// streamKeyed delays the packages of each key on its own. The packages are
+// kept sorted by the time they can go, which keeps the order of each key, as
+// its bucket hands out its times in order. A barrier stops the reading until
+// all the packages received before it are sent
+func (rl *RateLimiter) streamKeyed() {
+	c := rl.c
+	maxDelayed := rl.spec.MaxDelayed
+	if maxDelayed < 1 {
+		maxDelayed = DefaultMaxDelayed
+	}
+
+	var (
+		delayed []delayedPackage
+		barrier *InformationPackage
+		closed  bool
+	)
+	for {
+		if closed && len(delayed) == 0 && barrier == nil {
+			return
+		}
+
+		var in, out chan *InformationPackage
+		if !closed && barrier == nil && len(delayed) < maxDelayed {
+			in = c.port.In
+		}
+		var next *InformationPackage
+		var timer Timer
+		var due <-chan time.Time
+		switch {
+		case len(delayed) > 0:
+			if wait := delayed[0].at.Sub(c.clock.Now()); wait > 0 {
+				timer = c.clock.NewTimer(wait)
+				due = timer.C()
+			} else {
+				out, next = c.port.Out, delayed[0].informationPackage
+			}
+		case barrier != nil:
+			out, next = c.port.Out, barrier
+		}
+
+		select {
+		case <-c.ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case informationPackage, ok := <-in:
+			switch {
+			case !ok:
+				c.logger.Warn("in port closed", Int("delayed", len(delayed)))
+				closed = true
+			case informationPackage.IsBarrier():
+				barrier = informationPackage
+			default:
+				delayed = rl.delay(delayed, informationPackage)
+			}
+		case out <- next:
+			if next == barrier {
+				barrier = nil
+			} else {
+				delayed = delayed[1:]
+			}
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// delay adds the package to the delayed ones, at the time its bucket lets it go
+func (rl *RateLimiter) delay(delayed []delayedPackage, informationPackage *InformationPackage) []delayedPackage {
+	now := rl.c.clock.Now()
+	at := now.Add(rl.bucket(rl.spec.Key(informationPackage), now).Reserve(now))
+	k := sort.Search(len(delayed), func(k int) bool { return delayed[k].at.After(at) })
+	delayed = append(delayed, delayedPackage{})
+	copy(delayed[k+1:], delayed[k:])
+	delayed[k] = delayedPackage{at: at, informationPackage: informationPackage}
+	return delayed
+}
+
+func (rl *RateLimiter) bucket(key string, now time.Time) *TokenBucket {
+	rl.seen++
+	if rl.seen%rateLimiterCleanupEvery == 0 {
+		for k, bucket := range rl.buckets {
+			if bucket.full(now) {
+				delete(rl.buckets, k)
+			}
+		}
+	}
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = NewTokenBucket(rl.spec.Rate, rl.spec.Burst)
+		rl.buckets[key] = bucket
+	}
+	return bucket
+}
@@ -0,0 +1,105 @@
+package fbp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+func TestRateLimiter(t *testing.T) {
+	parity := func(ip *fbp.InformationPackage) string { return fmt.Sprint(numberOf(ip) % 2) }
+	tests := []struct {
+		name    string
+		spec    fbp.RateLimitSpec
+		in      int
+		want    []string
+		delayed []string
+		dropped int
+	}{
+		{
+			name:    "drop",
+			spec:    fbp.RateLimitSpec{Rate: 1, Burst: 2, Mode: fbp.LimitDrop},
+			in:      4,
+			want:    []string{"n1", "n2"},
+			dropped: 2,
+		},
+		{
+			name:    "delay",
+			spec:    fbp.RateLimitSpec{Rate: 1, Burst: 1, Mode: fbp.LimitDelay},
+			in:      2,
+			want:    []string{"n1"},
+			delayed: []string{"n2"},
+		},
+		{
+			name:    "delay by key",
+			spec:    fbp.RateLimitSpec{Rate: 1, Burst: 1, Mode: fbp.LimitDelay, Key: parity},
+			in:      4,
+			want:    []string{"n1", "n2"},
+			delayed: []string{"n3", "n4"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			clock := fbp.NewFakeClock(time.Now())
+			bus := fbp.NewEventBus()
+			events, unsubscribe := bus.Subscribe(10)
+			defer unsubscribe()
+			errorHandler, errs := errorRecorder()
+			rateLimiter, err := fbp.NewRateLimiter(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			component := fbp.NewComponentWith(ctx, "rate_limiter", rateLimiter,
+				fbp.WithClock(clock), fbp.WithEventBus(bus), fbp.WithErrorHandler(errorHandler))
+			component.Stream()
+
+			for n := 1; n <= tc.in; n++ {
+				component.Port().In <- numberPackage(n)
+			}
+			if got := receiveIDs(t, component.Port().Out, len(tc.want)); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+
+			// The delayed packages go once their tokens are refilled
+			if len(tc.delayed) > 0 {
+				clock.BlockUntil(1)
+				clock.Advance(time.Second)
+				if got := receiveIDs(t, component.Port().Out, len(tc.delayed)); fmt.Sprint(got) != fmt.Sprint(tc.delayed) {
+					t.Errorf("got delayed %v, want %v", got, tc.delayed)
+				}
+			}
+
+			close(component.Port().In)
+			if err = component.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := drainIDs(component.Port().Out); len(got) > 0 {
+				t.Errorf("got %v, want no more packages", got)
+			}
+			if len(errs()) != tc.dropped {
+				t.Errorf("got %d errors, want %d", len(errs()), tc.dropped)
+			}
+			for _, err := range errs() {
+				if !errors.Is(err, fbp.ErrRateLimited) {
+					t.Errorf("got error %v, want %v", err, fbp.ErrRateLimited)
+				}
+			}
+			var dropped int
+			for len(events) > 0 {
+				if event := <-events; event.Kind == fbp.PackageDropped {
+					dropped++
+				}
+			}
+			if dropped != tc.dropped {
+				t.Errorf("got %d dropped events, want %d", dropped, tc.dropped)
+			}
+		})
+	}
+}
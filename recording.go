@@ -0,0 +1,339 @@
+package fbp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	A recording is a file with the packages that crossed a connection, one
+	record after another:
+
+	  time (8) | from len (2) | from | to len (2) | to | package len (4) | package
+
+	The time is in unix nanoseconds, the lengths are big endian, from and to
+	are the IDs of the ports of the connection, and the package is encoded
+	with the codec of the recorder, headers included.
+*/
+
+const maxRecordSz = maxFrameSz
+
+var ErrRecordTooLarge = errors.New("record too large")
+
+type (
+	// Record is a package that crossed a connection, and when it did
+	Record struct {
+		Time               time.Time
+		From               string
+		To                 string
+		InformationPackage *InformationPackage
+	}
+
+	// Recorder writes the packages of the connections it's given with
+	// Connection.Record to a recording file. It never blocks the
+	// connections: after a write error it logs it and stops recording
+	Recorder struct {
+		path   string
+		codec  Codec
+		logger Logger
+		clock  Clock
+
+		mux    sync.Mutex
+		file   *os.File
+		w      *bufio.Writer
+		err    error
+		closed bool
+	}
+
+	// RecordReader reads the records of a recording file in order
+	RecordReader struct {
+		file  *os.File
+		r     *bufio.Reader
+		codec Codec
+	}
+
+	// Replay is a source component that sends the packages of a recording
+	// to the out channel of its port, spaced as they were recorded
+	Replay struct {
+		ctx          context.Context
+		id           string
+		port         *Port
+		path         string
+		codec        Codec
+		speed        float64
+		clock        Clock
+		errorHandler *ErrorHandler
+		logger       Logger
+		events       *EventBus
+		done         chan struct{}
+	}
+)
+
+// NewRecorder creates the recording file at path, truncating it if it exists
+func NewRecorder(path string, codec Codec, logger Logger) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		path:   path,
+		codec:  codec,
+		logger: logger.With(String("recording", path)),
+		clock:  RealClock{},
+		file:   file,
+		w:      bufio.NewWriter(file),
+	}, nil
+}
+
+// SetClock sets the clock the records are timestamped with
+func (r *Recorder) SetClock(clock Clock) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.clock = clock
+}
+
+func (r *Recorder) record(from *Port, to *Port, informationPackage *InformationPackage) {
+	// The package is encoded before it's sent, as the next task can change it
+	data, err := r.codec.Encode(informationPackage)
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.closed || r.err != nil {
+		return
+	}
+	if err == nil {
+		err = writeRecord(r.w, r.clock.Now(), from.ID, to.ID, data)
+	}
+	if err != nil {
+		r.err = fmt.Errorf("recording package %s: %w", informationPackage.ID, err)
+		r.logger.Error("recording stopped", String("ip_id", informationPackage.ID), Err(err))
+	}
+}
+
+// Flush writes the buffered records to the file
+func (r *Recorder) Flush() (err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.closed {
+		return r.err
+	}
+	if err = r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// Close flushes the records and closes the file. It returns the error that
+// stopped the recording, if any
+func (r *Recorder) Close() (err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.closed {
+		return r.err
+	}
+	r.closed = true
+	if err = r.w.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err = r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func writeRecord(w io.Writer, t time.Time, from string, to string, data []byte) (err error) {
+	if len(from) > 0xffff || len(to) > 0xffff || len(data) > maxRecordSz {
+		return ErrRecordTooLarge
+	}
+	buf := make([]byte, 0, 8+2+len(from)+2+len(to)+4+len(data))
+	buf = append(buf, uint64Payload(uint64(t.UnixNano()))...)
+	buf = appendString(buf, from)
+	buf = appendString(buf, to)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(len(data)))
+	buf = append(buf, data...)
+	_, err = w.Write(buf)
+	return
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)))
+	return append(buf, s...)
+}
+
+// OpenRecording opens a recording file to read its records
+func OpenRecording(path string, codec Codec) (*RecordReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &RecordReader{
+		file:  file,
+		r:     bufio.NewReader(file),
+		codec: codec,
+	}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the recording
+func (rr *RecordReader) Next() (record Record, err error) {
+	header := make([]byte, 8)
+	if _, err = io.ReadFull(rr.r, header); err != nil {
+		return
+	}
+	record.Time = time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	if record.From, err = rr.readString(); err != nil {
+		return
+	}
+	if record.To, err = rr.readString(); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(rr.r, header[:4]); err != nil {
+		return record, unexpectedEOF(err)
+	}
+	sz := binary.BigEndian.Uint32(header[:4])
+	if sz > maxRecordSz {
+		return record, ErrRecordTooLarge
+	}
+	data := make([]byte, sz)
+	if _, err = io.ReadFull(rr.r, data); err != nil {
+		return record, unexpectedEOF(err)
+	}
+	record.InformationPackage, err = rr.codec.Decode(data)
+	return
+}
+
+func (rr *RecordReader) readString() (s string, err error) {
+	sz := make([]byte, 2)
+	if _, err = io.ReadFull(rr.r, sz); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(sz))
+	if _, err = io.ReadFull(rr.r, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(buf), nil
+}
+
+// unexpectedEOF tells apart a truncated record from the end of the recording
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (rr *RecordReader) Close() error {
+	return rr.file.Close()
+}
+
+// NewReplay creates a replay of the recording at path. The speed multiplies
+// the pace of the recording: 1 replays it at its original pace, 10 ten times
+// faster, and 0 or less sends the packages as fast as they are accepted
+func NewReplay(ctx context.Context, id string, port *Port, path string, codec Codec, speed float64, errorHandler *ErrorHandler, logger Logger) *Replay {
+	return &Replay{
+		ctx:          ctx,
+		id:           id,
+		port:         port,
+		path:         path,
+		codec:        codec,
+		speed:        speed,
+		clock:        RealClock{},
+		errorHandler: errorHandler,
+		logger:       logger.With(String("component_id", id), String("port_id", port.ID)),
+		done:         make(chan struct{}),
+	}
+}
+
+// SetClock sets the clock the packages are spaced with. It must be called
+// before streaming
+func (r *Replay) SetClock(clock Clock) {
+	r.clock = clock
+}
+
+// SetEventBus makes the replay publish its lifecycle events. It must be
+// called before streaming
+func (r *Replay) SetEventBus(bus *EventBus) {
+	r.events = bus
+}
+
+// Done is closed when the replay ends, because the recording was all sent,
+// it failed or the context was canceled
+func (r *Replay) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *Replay) Stream() {
+	go func() {
+		defer close(r.done)
+		r.logger.Info("replay starting", Float64("speed", r.speed))
+		r.events.Publish(Event{Kind: ComponentStarted, Source: r.id, Port: r.port.ID})
+		defer r.events.Publish(Event{Kind: ComponentStopped, Source: r.id, Port: r.port.ID})
+
+		sent, err := r.replay()
+		if err != nil {
+			r.errorHandler.Handle(fmt.Errorf("replay %s, recording %s: %w", r.id, r.path, err))
+			return
+		}
+		r.logger.Info("replay finished", Int("packages", sent))
+	}()
+
+	return
+}
+
+func (r *Replay) replay() (sent int, err error) {
+	reader, err := OpenRecording(r.path, r.codec)
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	var last time.Time
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+		if r.speed > 0 && !last.IsZero() {
+			if !r.wait(time.Duration(float64(record.Time.Sub(last)) / r.speed)) {
+				return sent, nil
+			}
+		}
+		last = record.Time
+
+		select {
+		case <-r.ctx.Done():
+			return sent, nil
+		case r.port.Out <- record.InformationPackage:
+			sent++
+		}
+	}
+}
+
+func (r *Replay) wait(d time.Duration) bool {
+	if d <= 0 {
+		return r.ctx.Err() == nil
+	}
+	timer := r.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
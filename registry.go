@@ -0,0 +1,70 @@
+package fbp
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrComponentAlreadyRegistered = errors.New("component already registered")
+	ErrComponentNotRegistered     = errors.New("component not registered")
+)
+
+type (
+	// ComponentSpec describes a component kind that networks can instantiate
+	// by name. New is called once for each node using the component
+	ComponentSpec struct {
+		Name        string
+		Description string
+		New         func() Task
+	}
+
+	Registry struct {
+		mux   sync.RWMutex
+		specs map[string]ComponentSpec
+	}
+)
+
+func NewRegistry() *Registry {
+	return &Registry{
+		specs: make(map[string]ComponentSpec),
+	}
+}
+
+func (r *Registry) Register(spec ComponentSpec) (err error) {
+	if spec.Name == "" || spec.New == nil {
+		return errors.New("component spec requires a name and a task constructor")
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if _, ok := r.specs[spec.Name]; ok {
+		return ErrComponentAlreadyRegistered
+	}
+	r.specs[spec.Name] = spec
+	return
+}
+
+func (r *Registry) Get(name string) (spec ComponentSpec, err error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	spec, ok := r.specs[name]
+	if !ok {
+		err = ErrComponentNotRegistered
+	}
+	return
+}
+
+func (r *Registry) List() (specs []ComponentSpec) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+
+	for _, spec := range r.specs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return
+}
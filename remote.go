@@ -0,0 +1,429 @@
+package fbp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+	Remote connections stream information packages between processes over TCP.
+
+	sender                              receiver
+	  hello(epoch, id)    ------------->
+	                      <------------- credit(window)
+	  data(seq, ip)       ------------->   to.In <- ip
+	                      <------------- ack(seq)
+	  done(seq)           ------------->
+
+	Every ack gives back one credit to the sender, and the receiver only acks
+	once the package has been accepted by the destination port. So a slow
+	destination stops the acks, the sender runs out of credits and stops
+	reading from its port, preserving backpressure across the wire.
+	Unacked packages are resent after a reconnection, and the receiver drops
+	the sequence numbers it has already delivered. The epoch is random for
+	each sender, so when a sender restarts and numbers its packages from one
+	again, the receiver forgets the ones delivered in the previous epoch.
+	Once its port is closed and all its packages are acked, the sender says
+	it's done with its last sequence number, and the receiver forgets it.
+*/
+
+const (
+	frameHello byte = iota + 1
+	frameCredit
+	frameData
+	frameAck
+	frameDone
+
+	frameHeaderSz = 5
+	maxFrameSz    = 64 << 20
+
+	remoteMinBackoff = 100 * time.Millisecond
+	remoteMaxBackoff = 10 * time.Second
+)
+
+var ErrFrameTooLarge = errors.New("remote frame too large")
+
+type (
+	frame struct {
+		kind    byte
+		payload []byte
+	}
+
+	pendingPackage struct {
+		seq     uint64
+		payload []byte
+	}
+
+	remoteSender struct {
+		ctx     context.Context
+		id      string
+		epoch   uint64
+		addr    string
+		from    *Port
+		codec   Codec
+		logger  Logger
+		seq     uint64
+		pending []pendingPackage
+		closed  bool
+	}
+
+	// remoteSession is the last sequence number delivered from a sender
+	// epoch. As the packages are delivered in order, it's all the receiver
+	// needs to drop the resent ones
+	remoteSession struct {
+		epoch uint64
+		seq   uint64
+	}
+
+	remoteReceiver struct {
+		ctx       context.Context
+		id        string
+		to        *Port
+		codec     Codec
+		window    int
+		logger    Logger
+		mux       sync.Mutex
+		delivered map[string]remoteSession
+	}
+)
+
+func writeFrame(w io.Writer, kind byte, payload []byte) (err error) {
+	buf := make([]byte, frameHeaderSz+len(payload))
+	buf[0] = kind
+	binary.BigEndian.PutUint32(buf[1:frameHeaderSz], uint32(len(payload)))
+	copy(buf[frameHeaderSz:], payload)
+	_, err = w.Write(buf)
+	return
+}
+
+func readFrame(r io.Reader) (f frame, err error) {
+	header := make([]byte, frameHeaderSz)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	sz := binary.BigEndian.Uint32(header[1:])
+	if sz > maxFrameSz {
+		err = ErrFrameTooLarge
+		return
+	}
+	f.kind = header[0]
+	f.payload = make([]byte, sz)
+	_, err = io.ReadFull(r, f.payload)
+	return
+}
+
+func uint64Payload(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// StreamRemote sends the packages read from the from port to a remote
+// receiver started with ListenRemote, reconnecting with backoff when the
+// connection is lost
+func (c *Connection) StreamRemote(from *Port, addr string, codec Codec) (err error) {
+	s := &remoteSender{
+		ctx:    c.ctx,
+		id:     c.ID,
+		epoch:  newEpoch(),
+		addr:   addr,
+		from:   from,
+		codec:  codec,
+		logger: c.logger,
+	}
+	go s.run()
+	c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID, Detail: addr})
+	return
+}
+
+// newEpoch returns a random epoch, falling back to the clock
+func newEpoch() uint64 {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (s *remoteSender) run() {
+	s.logger.Info("starting remote connection", String("addr", s.addr))
+	backoff := remoteMinBackoff
+	for {
+		conn, err := (&net.Dialer{}).DialContext(s.ctx, "tcp", s.addr)
+		if err == nil {
+			backoff = remoteMinBackoff
+			err = s.serve(conn)
+			conn.Close()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			s.logger.Info("remote connection finished", String("addr", s.addr))
+			return
+		}
+		s.logger.Warn("remote connection lost", Err(err), Duration("backoff", backoff))
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > remoteMaxBackoff {
+			backoff = remoteMaxBackoff
+		}
+	}
+}
+
+// serve streams the packages over a connection. It returns nil once the
+// from port is closed and all the packages have been acked
+func (s *remoteSender) serve(conn net.Conn) (err error) {
+	if err = writeFrame(conn, frameHello, append(uint64Payload(s.epoch), s.id...)); err != nil {
+		return
+	}
+
+	frames := make(chan frame)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			f, err := readFrame(conn)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case frames <- f:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	credits, sent := 0, 0
+	for {
+		if s.closed && len(s.pending) == 0 {
+			return writeFrame(conn, frameDone, uint64Payload(s.seq))
+		}
+		for sent < len(s.pending) && credits > 0 {
+			if err = writeFrame(conn, frameData, s.pending[sent].payload); err != nil {
+				return
+			}
+			sent++
+			credits--
+		}
+
+		// Only read new packages when everything pending is on the wire
+		var in chan *InformationPackage
+		if credits > 0 && sent == len(s.pending) && !s.closed {
+			in = s.from.Out
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		case err = <-readErr:
+			return
+		case f := <-frames:
+			switch f.kind {
+			case frameCredit:
+				if len(f.payload) < 4 {
+					return fmt.Errorf("short remote credit frame of %d bytes", len(f.payload))
+				}
+				credits += int(binary.BigEndian.Uint32(f.payload))
+			case frameAck:
+				if len(f.payload) < 8 {
+					return fmt.Errorf("short remote ack frame of %d bytes", len(f.payload))
+				}
+				acked := s.ack(binary.BigEndian.Uint64(f.payload))
+				credits += acked
+				sent -= acked
+				if sent < 0 {
+					sent = 0
+				}
+			default:
+				return fmt.Errorf("unexpected remote frame type %d", f.kind)
+			}
+		case informationPackage, ok := <-in:
+			if !ok {
+				// The packages on the wire are still acked, or resent after a reconnection
+				s.logger.Warn("out port closed", String("port_id", s.from.ID), Int("pending", len(s.pending)))
+				s.closed = true
+				continue
+			}
+			data, err := s.codec.Encode(informationPackage)
+			if err != nil {
+				s.logger.Error("encoding information package", String("ip_id", informationPackage.ID), Err(err))
+				continue
+			}
+			s.seq++
+			s.pending = append(s.pending, pendingPackage{
+				seq:     s.seq,
+				payload: append(uint64Payload(s.seq), data...),
+			})
+		}
+	}
+}
+
+// ack removes the pending packages up to seq, returning how many were removed
+func (s *remoteSender) ack(seq uint64) (n int) {
+	for n < len(s.pending) && s.pending[n].seq <= seq {
+		n++
+	}
+	s.pending = s.pending[n:]
+	return
+}
+
+// ListenRemote accepts remote connections on addr and delivers the received
+// packages to the to port, granting at most window packages in flight per
+// sender. It returns the listening address, which is useful when addr uses port 0
+func (c *Connection) ListenRemote(addr string, to *Port, codec Codec, window int) (listenAddr net.Addr, err error) {
+	if window < 1 {
+		return nil, errors.New("remote window must be greater than zero")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	r := &remoteReceiver{
+		ctx:       c.ctx,
+		id:        c.ID,
+		to:        to,
+		codec:     codec,
+		window:    window,
+		logger:    c.logger,
+		delivered: make(map[string]remoteSession),
+	}
+	go func() {
+		<-c.ctx.Done()
+		ln.Close()
+	}()
+	go r.accept(ln)
+	listenAddr = ln.Addr()
+	c.events.Publish(Event{Kind: ConnectionStarted, Source: c.ID, Port: to.ID, Detail: listenAddr.String()})
+	return
+}
+
+func (r *remoteReceiver) accept(ln net.Listener) {
+	r.logger.Info("listening remote connections", String("addr", ln.Addr().String()))
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if r.ctx.Err() == nil {
+				r.logger.Error("accepting remote connection", Err(err))
+			}
+			return
+		}
+		go func() {
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-r.ctx.Done():
+				case <-done:
+				}
+				conn.Close()
+			}()
+			if err := r.serve(conn); err != nil && err != io.EOF && r.ctx.Err() == nil {
+				r.logger.Warn("remote connection closed", Err(err))
+			}
+		}()
+	}
+}
+
+func (r *remoteReceiver) serve(conn net.Conn) (err error) {
+	hello, err := readFrame(conn)
+	if err != nil {
+		return
+	}
+	if hello.kind != frameHello {
+		return fmt.Errorf("expected hello frame, got type %d", hello.kind)
+	}
+	if len(hello.payload) < 8 {
+		return fmt.Errorf("short remote hello frame of %d bytes", len(hello.payload))
+	}
+	epoch := binary.BigEndian.Uint64(hello.payload)
+	sender := string(hello.payload[8:])
+	r.hello(sender, epoch)
+
+	credit := make([]byte, 4)
+	binary.BigEndian.PutUint32(credit, uint32(r.window))
+	if err = writeFrame(conn, frameCredit, credit); err != nil {
+		return
+	}
+
+	for {
+		var f frame
+		if f, err = readFrame(conn); err != nil {
+			return
+		}
+		if (f.kind != frameData && f.kind != frameDone) || len(f.payload) < 8 {
+			return fmt.Errorf("unexpected remote frame type %d", f.kind)
+		}
+		seq := binary.BigEndian.Uint64(f.payload)
+		if f.kind == frameDone {
+			r.forget(sender, epoch, seq)
+			return nil
+		}
+		if r.claim(sender, epoch, seq) {
+			informationPackage, err := r.codec.Decode(f.payload[8:])
+			if err != nil {
+				r.logger.Error("decoding information package", Err(err))
+			} else {
+				select {
+				case <-r.ctx.Done():
+					return r.ctx.Err()
+				case r.to.In <- informationPackage:
+				}
+			}
+		}
+		if err = writeFrame(conn, frameAck, uint64Payload(seq)); err != nil {
+			return
+		}
+	}
+}
+
+// hello starts over the sequence numbers of a sender when its epoch changes
+func (r *remoteReceiver) hello(sender string, epoch uint64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.delivered[sender].epoch != epoch {
+		r.delivered[sender] = remoteSession{epoch: epoch}
+	}
+}
+
+// forget removes a sender that is done, once everything it sent up to seq
+// has been delivered
+func (r *remoteReceiver) forget(sender string, epoch uint64, seq uint64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if session, ok := r.delivered[sender]; ok && session.epoch == epoch && session.seq >= seq {
+		delete(r.delivered, sender)
+	}
+}
+
+// claim reports whether a package has to be delivered, and marks it as
+// delivered, so two sessions of the same sender can't both deliver it. The
+// packages of an epoch replaced by a newer one are dropped
+func (r *remoteReceiver) claim(sender string, epoch uint64, seq uint64) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	session := r.delivered[sender]
+	if session.epoch != epoch || seq <= session.seq {
+		return false
+	}
+	session.seq = seq
+	r.delivered[sender] = session
+	return true
+}
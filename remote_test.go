@@ -0,0 +1,218 @@
+package fbp
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type remoteItem struct {
+	N int
+}
+
+func (ri remoteItem) Key() func() string {
+	return func() string {
+		return "remote_item"
+	}
+}
+
+func remoteCodecs() []struct {
+	name  string
+	codec Codec
+} {
+	gob.Register(remoteItem{})
+	jsonCodec := NewJSONCodec()
+	jsonCodec.Register(remoteItem{})
+	return []struct {
+		name  string
+		codec Codec
+	}{
+		{name: "gob", codec: NewGobCodec()},
+		{name: "json", codec: jsonCodec},
+	}
+}
+
+// remoteProxy forwards the connections to addr, and can cut them all at once
+type remoteProxy struct {
+	ln    net.Listener
+	mux   sync.Mutex
+	conns []net.Conn
+}
+
+func newRemoteProxy(t *testing.T, addr string) *remoteProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &remoteProxy{ln: ln}
+	go func() {
+		for {
+			in, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			out, err := net.Dial("tcp", addr)
+			if err != nil {
+				in.Close()
+				continue
+			}
+			p.mux.Lock()
+			p.conns = append(p.conns, in, out)
+			p.mux.Unlock()
+			go io.Copy(in, out)
+			go io.Copy(out, in)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		p.cut()
+	})
+	return p
+}
+
+func (p *remoteProxy) cut() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func receiveRemote(t *testing.T, port *Port, n int) (got []int) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for len(got) < n {
+		select {
+		case ip := <-port.In:
+			item, err := ip.Status.Peek(remoteItem{}.Key())
+			if err != nil {
+				t.Fatalf("package %s: %s", ip.ID, err)
+			}
+			got = append(got, item.(remoteItem).N)
+		case <-timeout:
+			t.Fatalf("received %d packages, want %d", len(got), n)
+		}
+	}
+	return
+}
+
+func TestRemoteCreditAck(t *testing.T) {
+	for _, tc := range remoteCodecs() {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			const window, packages = 2, 10
+			from := NewPort("from", nil, make(chan *InformationPackage))
+			to := NewPort("to", make(chan *InformationPackage), nil)
+			addr, err := NewConnection(ctx, "receiver", NewNopLogger()).ListenRemote("127.0.0.1:0", to, tc.codec, window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			NewConnection(ctx, "sender", NewNopLogger()).StreamRemote(from, addr.String(), tc.codec)
+
+			var accepted int32
+			go func() {
+				for k := 1; k <= packages; k++ {
+					select {
+					case <-ctx.Done():
+						return
+					case from.Out <- NewInformationPackage(fmt.Sprintf("ip%d", k), remoteItem{N: k}):
+						atomic.AddInt32(&accepted, 1)
+					}
+				}
+				close(from.Out)
+			}()
+
+			// Nothing reads the to port, so the sender runs out of credits
+			time.Sleep(200 * time.Millisecond)
+			if n := atomic.LoadInt32(&accepted); n != window {
+				t.Fatalf("the sender read %d packages without acks, want %d", n, window)
+			}
+
+			got := receiveRemote(t, to, packages)
+			for k, n := range got {
+				if n != k+1 {
+					t.Fatalf("got packages %v, want them in order", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteReconnect(t *testing.T) {
+	for _, tc := range remoteCodecs() {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			const packages = 60
+			from := NewPort("from", nil, make(chan *InformationPackage))
+			to := NewPort("to", make(chan *InformationPackage), nil)
+			addr, err := NewConnection(ctx, "receiver", NewNopLogger()).ListenRemote("127.0.0.1:0", to, tc.codec, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxy := newRemoteProxy(t, addr.String())
+			NewConnection(ctx, "sender", NewNopLogger()).StreamRemote(from, proxy.ln.Addr().String(), tc.codec)
+
+			go func() {
+				for k := 1; k <= packages; k++ {
+					select {
+					case <-ctx.Done():
+						return
+					case from.Out <- NewInformationPackage(fmt.Sprintf("ip%d", k), remoteItem{N: k}):
+					}
+				}
+			}()
+
+			// The connection is cut with packages in flight, so some are
+			// delivered without their ack getting back, and resent
+			var got []int
+			for len(got) < packages {
+				got = append(got, receiveRemote(t, to, 15)...)
+				proxy.cut()
+			}
+			for k, n := range got {
+				if n != k+1 {
+					t.Fatalf("got packages %v, want each of them once and in order", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoteReceiverForget(t *testing.T) {
+	r := &remoteReceiver{delivered: make(map[string]remoteSession)}
+
+	r.hello("sender", 1)
+	for _, seq := range []uint64{1, 2} {
+		if !r.claim("sender", 1, seq) {
+			t.Fatalf("package %d not delivered", seq)
+		}
+	}
+	if r.claim("sender", 1, 2) {
+		t.Fatal("resent package delivered twice")
+	}
+	if r.claim("sender", 2, 3) {
+		t.Fatal("package of an unknown epoch delivered")
+	}
+
+	r.forget("sender", 1, 3)
+	if _, ok := r.delivered["sender"]; !ok {
+		t.Fatal("sender forgotten before its last package was delivered")
+	}
+	r.forget("sender", 1, 2)
+	if _, ok := r.delivered["sender"]; ok {
+		t.Fatal("sender done but not forgotten")
+	}
+}
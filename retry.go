@@ -0,0 +1,147 @@
+package fbp
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+const (
+	// AttemptsHeader holds the number of times the task has run for a package
+	AttemptsHeader = "fbp-attempts"
+	// ErrorHeader holds the last error of the packages sent to an error port
+	ErrorHeader = "fbp-error"
+)
+
+type (
+	// RetryPolicy retries the task for a package up to MaxAttempts times,
+	// counting the first one. The backoff starts at InitialBackoff and is
+	// multiplied by Multiplier after each attempt, up to MaxBackoff. Jitter
+	// is the fraction of the backoff, between 0 and 1, it's randomized by
+	RetryPolicy struct {
+		MaxAttempts    int
+		InitialBackoff time.Duration
+		MaxBackoff     time.Duration
+		Multiplier     float64
+		Jitter         float64
+	}
+
+	// Retryable classifies the errors. The errors not implementing it are retried
+	Retryable interface {
+		Retryable() bool
+	}
+
+	permanentError struct {
+		err error
+	}
+)
+
+// Permanent marks an error as not retryable
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func (e permanentError) Retryable() bool {
+	return false
+}
+
+// IsRetryable reports whether an error or any error it wraps isn't
+// classified as not retryable
+func IsRetryable(err error) bool {
+	var retryable Retryable
+	if errors.As(err, &retryable) {
+		return retryable.Retryable()
+	}
+	return true
+}
+
+// Backoff returns how long to wait after the given attempt
+func (rp RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && backoff > float64(rp.MaxBackoff) {
+		backoff = float64(rp.MaxBackoff)
+	}
+	if rp.Jitter > 0 {
+		backoff += backoff * rp.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// SetRetryPolicy makes the component retry the task when it fails. It must
+// be set before streaming
+func (c *Component) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = &policy
+}
+
+// SetErrorPort sets the dead letter port of the component. The packages the
+// task fails for, once the retries are exhausted, are sent to its out channel
+// with the ErrorHeader, instead of being handled by the error handler. It must
+// be set before streaming
+func (c *Component) SetErrorPort(port *Port) {
+	c.errorPort = port
+}
+
+// retry runs the task for a package as many times as the retry policy allows.
+// Each attempt gets a copy of the package with its own headers, holding the
+// AttemptsHeader, and the same status set, so the task finds the items by
+// their keys. The last one is returned as attempted. An attempt that timed
+// out is waited for before starting the next one, so the attempts never run
+// at once
+func (c *Component) retry(in *InformationPackage) (attempted *InformationPackage, out *InformationPackage, err error) {
+	attempted = in
+	for attempt := 1; ; attempt++ {
+		if c.retryPolicy != nil {
+			attempted = CloneHeaders(in)
+			attempted.SetHeader(AttemptsHeader, strconv.Itoa(attempt))
+		}
+		var running <-chan taskResult
+		out, running, err = c.do(attempted)
+		if err == nil || c.ctx.Err() != nil || c.retryPolicy == nil {
+			return
+		}
+		if attempt >= c.retryPolicy.MaxAttempts || !IsRetryable(err) {
+			return
+		}
+
+		if running != nil {
+			c.logger.Warn("waiting for the timed out attempt to end", String("ip_id", in.ID), Int("attempt", attempt))
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-running:
+			}
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.clock.After(c.retryPolicy.Backoff(attempt)):
+		}
+	}
+}
+
+// deadLetter sends a copy of the headers of a failed package to the error
+// port, as the task may still be running with it after a timeout
+func (c *Component) deadLetter(in *InformationPackage, err error) bool {
+	dead := CloneHeaders(in)
+	dead.SetHeader(ErrorHeader, err.Error())
+	select {
+	case <-c.ctx.Done():
+		return false
+	case c.errorPort.Out <- dead:
+		return true
+	}
+}
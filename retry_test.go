@@ -0,0 +1,81 @@
+package fbp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+func amountKey() string {
+	return "amount"
+}
+
+// flakyTask fails the first failures attempts, and any attempt not finding
+// the amount of the package by its key
+type flakyTask struct {
+	failures int
+	attempts int
+}
+
+func (ft *flakyTask) Do(in *fbp.InformationPackage) (out *fbp.InformationPackage, err error) {
+	ft.attempts++
+	if _, err = in.Status.Peek(amountKey); err != nil {
+		return nil, fbp.Permanent(err)
+	}
+	if ft.attempts <= ft.failures {
+		return nil, errors.New("flaky")
+	}
+	return in, nil
+}
+
+func TestRetryKeepsStatusKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     string
+		dead     bool
+	}{
+		{name: "retried", failures: 1, want: "2"},
+		{name: "dead letter", failures: 3, want: "3", dead: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			task := &flakyTask{failures: tc.failures}
+			errorPort := fbp.NewPort("errors", nil, make(chan *fbp.InformationPackage, 1))
+			component := fbp.NewComponentWith(ctx, "flaky", task,
+				fbp.WithRetryPolicy(fbp.RetryPolicy{MaxAttempts: 3}), fbp.WithErrorPort(errorPort))
+			component.Stream()
+
+			// The amount is not a KeyGetter, so only its key finds it
+			in := fbp.NewInformationPackage("ip", nil)
+			in.Status.Add(amountKey, 10)
+			component.Port().In <- in
+
+			out := component.Port().Out
+			if tc.dead {
+				out = errorPort.Out
+			}
+			var ip *fbp.InformationPackage
+			select {
+			case ip = <-out:
+			case <-time.After(5 * time.Second):
+				t.Fatal("package not received")
+			}
+			if got := ip.Header(fbp.AttemptsHeader); got != tc.want {
+				t.Errorf("got %s attempts, want %s", got, tc.want)
+			}
+			if amount, err := ip.Status.Peek(amountKey); err != nil || amount.(int) != 10 {
+				t.Errorf("got amount %v, %v, want 10", amount, err)
+			}
+			if in.Header(fbp.AttemptsHeader) != "" {
+				t.Error("attempts header set on the received package")
+			}
+		})
+	}
+}
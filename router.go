@@ -0,0 +1,127 @@
+package fbp
+
+import (
+	"fmt"
+)
+
+const (
+	// RouteFirstMatch sends each package to the first route matching it
+	RouteFirstMatch RouteMode = iota
+	// RouteAllMatches sends each package to all the routes matching it. The
+	// routes share the same package, which must be handled as read only
+	RouteAllMatches
+)
+
+const DefaultRoute = "default"
+
+type (
+	RouteMode int
+
+	route struct {
+		name      string
+		predicate Predicate
+		port      *Port
+	}
+
+	// Router is the task of a component that sends each package it receives
+	// to the out channel of the port of the routes matching it. The packages
+	// not matching any route go to the component's own out channel, the
+	// default route
+	Router struct {
+		streamer
+		mode   RouteMode
+		routes []route
+		c      *Component
+	}
+)
+
+func NewRouter(mode RouteMode) *Router {
+	return &Router{
+		mode: mode,
+	}
+}
+
+// AddRoute adds a named route, evaluated in the order they are added. It
+// must be called before streaming
+func (r *Router) AddRoute(name string, predicate Predicate, out *Port) (err error) {
+	if name == DefaultRoute {
+		return fmt.Errorf("route name %s is reserved", DefaultRoute)
+	}
+	for _, rt := range r.routes {
+		if rt.name == name {
+			return fmt.Errorf("route %s already exists", name)
+		}
+	}
+	r.routes = append(r.routes, route{
+		name:      name,
+		predicate: predicate,
+		port:      out,
+	})
+	return
+}
+
+// AddExpressionRoute adds a route whose predicate is written in the
+// expression language of ParsePredicate
+func (r *Router) AddExpressionRoute(name string, expression string, out *Port) (err error) {
+	predicate, err := ParsePredicate(expression)
+	if err != nil {
+		return fmt.Errorf("route %s: %s", name, err)
+	}
+	return r.AddRoute(name, predicate, out)
+}
+
+func (r *Router) stream(c *Component) {
+	r.c = c
+	c.logger.Info("router starting", Int("routes", len(r.routes)))
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case informationPackage, ok := <-c.port.In:
+			if !ok {
+				c.logger.Warn("in port closed")
+				return
+			}
+			for _, port := range r.match(informationPackage) {
+				if !c.sendTo(port, informationPackage) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// match returns the ports the package must be sent to
+func (r *Router) match(informationPackage *InformationPackage) (outs []*Port) {
+	if informationPackage.IsBarrier() {
+		for _, rt := range r.routes {
+			outs = append(outs, rt.port)
+		}
+		return append(outs, r.c.port)
+	}
+
+	for _, rt := range r.routes {
+		if !r.evaluate(rt, informationPackage) {
+			continue
+		}
+		outs = append(outs, rt.port)
+		if r.mode == RouteFirstMatch {
+			return
+		}
+	}
+	if len(outs) == 0 {
+		outs = append(outs, r.c.port)
+	}
+	return
+}
+
+// evaluate runs the route predicate, handling its panics as a no match
+func (r *Router) evaluate(rt route, informationPackage *InformationPackage) (matched bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			r.c.errorHandler.Handle(fmt.Errorf("router %s, route %s, package %s: %v", r.c.id, rt.name, informationPackage.ID, e))
+			matched = false
+		}
+	}()
+	return rt.predicate(informationPackage)
+}
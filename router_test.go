@@ -0,0 +1,153 @@
+package fbp_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+type number struct {
+	N int
+}
+
+func (n number) Key() func() string {
+	return func() string {
+		return "number"
+	}
+}
+
+func numberPackage(n int) *fbp.InformationPackage {
+	return fbp.NewInformationPackage(fmt.Sprintf("n%d", n), number{N: n})
+}
+
+func numberOf(ip *fbp.InformationPackage) int {
+	item, _ := ip.Status.Peek(number{}.Key())
+	return item.(number).N
+}
+
+// drainIDs returns the IDs of the packages waiting in a channel
+func drainIDs(ch chan *fbp.InformationPackage) (ids []string) {
+	for {
+		select {
+		case ip := <-ch:
+			ids = append(ids, ip.ID)
+		default:
+			return
+		}
+	}
+}
+
+// receiveIDs waits for n packages from a channel, returning their IDs
+func receiveIDs(t *testing.T, ch chan *fbp.InformationPackage, n int) (ids []string) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for len(ids) < n {
+		select {
+		case ip := <-ch:
+			ids = append(ids, ip.ID)
+		case <-timeout:
+			t.Fatalf("received %v, want %d packages", ids, n)
+		}
+	}
+	return
+}
+
+// errorRecorder returns an error handler keeping the errors it handles
+func errorRecorder() (*fbp.ErrorHandler, func() []error) {
+	var (
+		mux  sync.Mutex
+		errs []error
+	)
+	errorHandler := fbp.NewErrorHandlerFunc(fbp.NewNopLogger(), func(err error) {
+		mux.Lock()
+		defer mux.Unlock()
+		errs = append(errs, err)
+	})
+	return errorHandler, func() []error {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]error(nil), errs...)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		name string
+		mode fbp.RouteMode
+		want map[string][]string
+	}{
+		{
+			name: "first match",
+			mode: fbp.RouteFirstMatch,
+			want: map[string][]string{
+				"big":            {"n12", "barrier_1"},
+				"even":           {"n4", "barrier_1"},
+				fbp.DefaultRoute: {"n3", "barrier_1"},
+			},
+		},
+		{
+			name: "all matches",
+			mode: fbp.RouteAllMatches,
+			want: map[string][]string{
+				"big":            {"n12", "barrier_1"},
+				"even":           {"n12", "n4", "barrier_1"},
+				fbp.DefaultRoute: {"n3", "barrier_1"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			router := fbp.NewRouter(tc.mode)
+			routes := map[string]*fbp.Port{
+				"big":  fbp.NewPort("big", nil, make(chan *fbp.InformationPackage, 16)),
+				"even": fbp.NewPort("even", nil, make(chan *fbp.InformationPackage, 16)),
+			}
+			if err := router.AddExpressionRoute("big", "payload.N > 10", routes["big"]); err != nil {
+				t.Fatal(err)
+			}
+			even := func(ip *fbp.InformationPackage) bool {
+				// The predicate panics are handled as no match
+				if numberOf(ip) == 3 {
+					panic("three")
+				}
+				return numberOf(ip)%2 == 0
+			}
+			if err := router.AddRoute("even", even, routes["even"]); err != nil {
+				t.Fatal(err)
+			}
+			if err := router.AddRoute(fbp.DefaultRoute, even, routes["even"]); err == nil {
+				t.Error("the default route name was accepted")
+			}
+
+			errorHandler, errs := errorRecorder()
+			component := fbp.NewComponentWith(ctx, "router", router, fbp.WithErrorHandler(errorHandler))
+			component.Stream()
+			routes[fbp.DefaultRoute] = component.Port()
+
+			for _, ip := range []*fbp.InformationPackage{numberPackage(12), numberPackage(3), numberPackage(4), fbp.NewBarrier(1)} {
+				component.Port().In <- ip
+			}
+			close(component.Port().In)
+			if err := component.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tc.want {
+				if got := drainIDs(routes[name].Out); fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("route %s got %v, want %v", name, got, want)
+				}
+			}
+			if n := len(errs()); n != 1 {
+				t.Errorf("got %d errors, want the panic one", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,597 @@
+package fbp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+	RuntimeServer implements the FBP network protocol
+	(https://flowbased.github.io/fbp-protocol/) over WebSocket, so tools like
+	noflo-ui can list the registry components, edit the graphs, start and stop
+	the networks and watch the packages flowing through the edges.
+
+	Every graph is backed by a Network. The nodes have a single in port named
+	"in" and a single out port named "out", like the components do.
+*/
+
+const (
+	RuntimeProtocolVersion = "0.7"
+	RuntimeType            = "fbp-go"
+	RuntimeSubprotocol     = "noflo"
+
+	inPortName  = "in"
+	outPortName = "out"
+
+	// runtimeTapBuffer is how many packages of a watched edge can wait to be
+	// sent to the clients before the next ones are dropped
+	runtimeTapBuffer = 64
+)
+
+var ErrInvalidRuntimeSecret = errors.New("invalid runtime secret")
+
+var runtimeCapabilities = []string{
+	"protocol:runtime",
+	"protocol:graph",
+	"protocol:component",
+	"protocol:network",
+	"network:control",
+	"network:status",
+	"network:data",
+}
+
+type (
+	runtimeMessage struct {
+		Protocol string          `json:"protocol"`
+		Command  string          `json:"command"`
+		Payload  json.RawMessage `json:"payload,omitempty"`
+		Secret   string          `json:"secret,omitempty"`
+	}
+
+	runtimeEndpoint struct {
+		Node string      `json:"node,omitempty"`
+		Port string      `json:"port,omitempty"`
+		Data interface{} `json:"data,omitempty"`
+	}
+
+	runtimeEdge struct {
+		Src runtimeEndpoint `json:"src"`
+		Tgt runtimeEndpoint `json:"tgt"`
+	}
+
+	runtimePayload struct {
+		Graph     string           `json:"graph,omitempty"`
+		ID        string           `json:"id,omitempty"`
+		Component string           `json:"component,omitempty"`
+		Src       *runtimeEndpoint `json:"src,omitempty"`
+		Tgt       *runtimeEndpoint `json:"tgt,omitempty"`
+		Public    string           `json:"public,omitempty"`
+		Node      string           `json:"node,omitempty"`
+		Port      string           `json:"port,omitempty"`
+		Edges     []runtimeEdge    `json:"edges,omitempty"`
+		Event     string           `json:"event,omitempty"`
+		Payload   interface{}      `json:"payload,omitempty"`
+	}
+
+	runtimePort struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+
+	RuntimeServer struct {
+		ctx          context.Context
+		registry     *Registry
+		errorHandler *ErrorHandler
+		logger       Logger
+
+		mux      sync.Mutex
+		secret   string
+		origins  map[string]bool
+		networks map[string]*Network
+		watched  map[string]map[Edge]bool
+		untap    map[string]func()
+		clients  map[*wsConn]struct{}
+	}
+)
+
+// NewRuntimeServer creates a runtime serving the networks built from the
+// registry. A nil error handler or logger defaults to a no-op one
+func NewRuntimeServer(ctx context.Context, registry *Registry, errorHandler *ErrorHandler, logger Logger) *RuntimeServer {
+	if logger == nil {
+		logger = NewNopLogger()
+	}
+	if errorHandler == nil {
+		errorHandler = NewErrorHandler(logger)
+	}
+	return &RuntimeServer{
+		ctx:          ctx,
+		registry:     registry,
+		errorHandler: errorHandler,
+		logger:       logger,
+		networks:     make(map[string]*Network),
+		origins:      make(map[string]bool),
+		watched:      make(map[string]map[Edge]bool),
+		untap:        make(map[string]func()),
+		clients:      make(map[*wsConn]struct{}),
+	}
+}
+
+// SetSecret makes the runtime reject the messages not carrying the secret.
+// An empty secret accepts every message
+func (rs *RuntimeServer) SetSecret(secret string) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	rs.secret = secret
+}
+
+// AllowOrigins accepts the clients of other origins, like the noflo-ui one.
+// Only the clients of the runtime origin are accepted by default
+func (rs *RuntimeServer) AllowOrigins(origins ...string) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	for _, origin := range origins {
+		rs.origins[origin] = true
+	}
+}
+
+func (rs *RuntimeServer) allowedOrigin(r *http.Request) bool {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	return sameOrigin(r) || rs.origins[r.Header.Get("Origin")]
+}
+
+func (rs *RuntimeServer) validSecret(secret string) bool {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	return rs.secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(rs.secret)) == 1
+}
+
+// AddNetwork exposes an already built network as the graph with its ID
+func (rs *RuntimeServer) AddNetwork(network *Network) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	rs.addNetwork(network)
+}
+
+func (rs *RuntimeServer) addNetwork(network *Network) {
+	graph := network.ID
+	if untap, ok := rs.untap[graph]; ok {
+		untap()
+		delete(rs.untap, graph)
+	}
+	rs.networks[graph] = network
+	rs.watched[graph] = make(map[Edge]bool)
+}
+
+// tap subscribes to the connections of the watched edges of a graph, instead
+// of the ones watched before. It must be called holding the lock
+func (rs *RuntimeServer) tap(graph string) {
+	if untap, ok := rs.untap[graph]; ok {
+		untap()
+	}
+
+	network := rs.networks[graph]
+	var unsubscribes []func()
+	tapped := make(map[string]bool)
+	for edge := range rs.watched[graph] {
+		if tapped[edge.From] {
+			continue
+		}
+		tapped[edge.From] = true
+		records, unsubscribe := network.Subscribe(edge.From, runtimeTapBuffer, 1)
+		unsubscribes = append(unsubscribes, unsubscribe)
+		go func() {
+			for record := range records {
+				rs.packageSent(network, graph, Edge{From: record.From, To: record.To}, record.InformationPackage)
+			}
+		}()
+	}
+	rs.untap[graph] = func() {
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}
+}
+
+func (rs *RuntimeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !rs.allowedOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		rs.logger.Warn("runtime client rejected", String("origin", r.Header.Get("Origin")))
+		return
+	}
+	ws, err := upgradeWebSocket(w, r, RuntimeSubprotocol)
+	if err != nil {
+		rs.logger.Warn("runtime client rejected", Err(err))
+		return
+	}
+	defer ws.Close()
+
+	rs.mux.Lock()
+	rs.clients[ws] = struct{}{}
+	rs.mux.Unlock()
+	defer func() {
+		rs.mux.Lock()
+		delete(rs.clients, ws)
+		rs.mux.Unlock()
+	}()
+
+	rs.logger.Info("runtime client connected", String("remote_addr", r.RemoteAddr))
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				rs.logger.Warn("runtime client disconnected", Err(err))
+			}
+			return
+		}
+		var msg runtimeMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			rs.sendError(ws, "", err)
+			continue
+		}
+		if !rs.validSecret(msg.Secret) {
+			rs.sendError(ws, msg.Protocol, ErrInvalidRuntimeSecret)
+			continue
+		}
+		if err = rs.handle(ws, msg); err != nil {
+			rs.sendError(ws, msg.Protocol, err)
+		}
+	}
+}
+
+func (rs *RuntimeServer) handle(ws *wsConn, msg runtimeMessage) (err error) {
+	var payload runtimePayload
+	if len(msg.Payload) > 0 {
+		if err = json.Unmarshal(msg.Payload, &payload); err != nil {
+			return
+		}
+	}
+
+	switch msg.Protocol + ":" + msg.Command {
+	case "runtime:getruntime":
+		return rs.send(ws, "runtime", "runtime", map[string]interface{}{
+			"type":            RuntimeType,
+			"version":         RuntimeProtocolVersion,
+			"capabilities":    runtimeCapabilities,
+			"allCapabilities": runtimeCapabilities,
+			"graph":           rs.mainGraph(),
+		})
+	case "runtime:packet":
+		return rs.packet(payload)
+	case "component:list":
+		return rs.listComponents(ws)
+	case "graph:clear":
+		return rs.clearGraph(msg, payload)
+	case "network:getstatus":
+		return rs.sendStatus(ws, "status", payload.Graph)
+	case "network:edges":
+		return rs.watchEdges(msg, payload)
+	}
+
+	network, err := rs.network(payload.Graph)
+	if err != nil {
+		return
+	}
+	switch msg.Protocol + ":" + msg.Command {
+	case "graph:addnode":
+		err = network.AddNode(payload.ID, payload.Component)
+	case "graph:removenode":
+		err = network.RemoveNode(payload.ID)
+	case "graph:addedge", "graph:removeedge":
+		if payload.Src == nil || payload.Tgt == nil {
+			return fmt.Errorf("%s requires src and tgt", msg.Command)
+		}
+		if err = checkPorts(payload.Src.Port, payload.Tgt.Port); err != nil {
+			return
+		}
+		if msg.Command == "addedge" {
+			err = network.AddEdge(payload.Src.Node, payload.Tgt.Node)
+		} else {
+			err = network.RemoveEdge(payload.Src.Node, payload.Tgt.Node)
+		}
+	case "graph:addinitial":
+		if payload.Src == nil || payload.Tgt == nil {
+			return fmt.Errorf("%s requires src and tgt", msg.Command)
+		}
+		if err = checkPorts("", payload.Tgt.Port); err != nil {
+			return
+		}
+		err = network.AddInitial(payload.Tgt.Node, newDataPackage(fmt.Sprintf("%s_initial", payload.Tgt.Node), payload.Src.Data))
+	case "graph:removeinitial":
+		if payload.Tgt == nil {
+			return fmt.Errorf("%s requires tgt", msg.Command)
+		}
+		network.RemoveInitials(payload.Tgt.Node)
+	case "graph:addinport":
+		err = network.AddInport(payload.Public, payload.Node)
+	case "graph:removeinport":
+		network.RemoveInport(payload.Public)
+	case "graph:addoutport":
+		err = network.AddOutport(payload.Public, payload.Node)
+	case "graph:removeoutport":
+		network.RemoveOutport(payload.Public)
+	case "network:start":
+		if err = network.Start(); err != nil {
+			return
+		}
+		rs.drainOutports(network, payload.Graph)
+		return rs.broadcastStatus("started", payload.Graph)
+	case "network:stop":
+		if err = network.Stop(); err != nil {
+			return
+		}
+		return rs.broadcastStatus("stopped", payload.Graph)
+	default:
+		return fmt.Errorf("unsupported command %s:%s", msg.Protocol, msg.Command)
+	}
+	if err != nil {
+		return
+	}
+	// Graph changes are echoed to every client, so all of them stay in sync
+	rs.broadcast(msg.Protocol, msg.Command, msg.Payload)
+	return
+}
+
+func checkPorts(src string, tgt string) error {
+	if src != "" && src != outPortName {
+		return fmt.Errorf("unknown out port %s", src)
+	}
+	if tgt != inPortName {
+		return fmt.Errorf("unknown in port %s", tgt)
+	}
+	return nil
+}
+
+func (rs *RuntimeServer) network(graph string) (network *Network, err error) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	network, ok := rs.networks[graph]
+	if !ok {
+		err = fmt.Errorf("graph %s not found", graph)
+	}
+	return
+}
+
+func (rs *RuntimeServer) mainGraph() (graph string) {
+	rs.mux.Lock()
+	defer rs.mux.Unlock()
+
+	for id := range rs.networks {
+		if graph == "" || id < graph {
+			graph = id
+		}
+	}
+	return
+}
+
+func (rs *RuntimeServer) listComponents(ws *wsConn) (err error) {
+	specs := rs.registry.List()
+	for _, spec := range specs {
+		err = rs.send(ws, "component", "component", map[string]interface{}{
+			"name":        spec.Name,
+			"description": spec.Description,
+			"subgraph":    false,
+			"inPorts":     []runtimePort{{ID: inPortName, Type: "all"}},
+			"outPorts":    []runtimePort{{ID: outPortName, Type: "all"}},
+		})
+		if err != nil {
+			return
+		}
+	}
+	return rs.send(ws, "component", "componentsready", len(specs))
+}
+
+func (rs *RuntimeServer) clearGraph(msg runtimeMessage, payload runtimePayload) (err error) {
+	if payload.ID == "" {
+		return fmt.Errorf("graph id is required")
+	}
+
+	rs.mux.Lock()
+	old := rs.networks[payload.ID]
+	rs.addNetwork(NewNetwork(rs.ctx, payload.ID, rs.registry, rs.errorHandler, rs.logger))
+	rs.mux.Unlock()
+
+	// Stopping takes the network lock and publishes its events, so it's done
+	// without holding the runtime one
+	if old != nil && old.Running() {
+		old.Stop()
+	}
+
+	rs.broadcast(msg.Protocol, msg.Command, msg.Payload)
+	return
+}
+
+func (rs *RuntimeServer) watchEdges(msg runtimeMessage, payload runtimePayload) (err error) {
+	rs.mux.Lock()
+	if _, ok := rs.networks[payload.Graph]; !ok {
+		rs.mux.Unlock()
+		return fmt.Errorf("graph %s not found", payload.Graph)
+	}
+	watched := make(map[Edge]bool, len(payload.Edges))
+	for _, edge := range payload.Edges {
+		watched[Edge{From: edge.Src.Node, To: edge.Tgt.Node}] = true
+	}
+	rs.watched[payload.Graph] = watched
+	rs.tap(payload.Graph)
+	rs.mux.Unlock()
+
+	rs.broadcast(msg.Protocol, msg.Command, msg.Payload)
+	return
+}
+
+func (rs *RuntimeServer) packet(payload runtimePayload) (err error) {
+	if payload.Event != "data" {
+		return
+	}
+	network, err := rs.network(payload.Graph)
+	if err != nil {
+		return
+	}
+	return network.Send(payload.Port, newDataPackage(fmt.Sprintf("%s_packet", payload.Port), payload.Payload))
+}
+
+// drainOutports forwards the packages of the exported out ports of a running
+// network to the clients, as nothing else reads them and the graph would block
+func (rs *RuntimeServer) drainOutports(network *Network, graph string) {
+	network.mux.RLock()
+	ctx := network.runCtx
+	publics := make([]string, 0, len(network.outports))
+	for public := range network.outports {
+		publics = append(publics, public)
+	}
+	network.mux.RUnlock()
+	if ctx == nil {
+		return
+	}
+
+	for _, public := range publics {
+		out, err := network.Outport(public)
+		if err != nil {
+			continue
+		}
+		go func(public string, out chan *InformationPackage) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case informationPackage, ok := <-out:
+					if !ok {
+						return
+					}
+					rs.broadcast("runtime", "packet", map[string]interface{}{
+						"graph":   graph,
+						"port":    public,
+						"event":   "data",
+						"payload": packageData(informationPackage),
+					})
+				}
+			}
+		}(public, out)
+	}
+}
+
+func (rs *RuntimeServer) sendStatus(ws *wsConn, command string, graph string) (err error) {
+	network, err := rs.network(graph)
+	if err != nil {
+		return
+	}
+	return rs.send(ws, "network", command, statusPayload(network, graph))
+}
+
+func (rs *RuntimeServer) broadcastStatus(command string, graph string) (err error) {
+	network, err := rs.network(graph)
+	if err != nil {
+		return
+	}
+	rs.broadcast("network", command, statusPayload(network, graph))
+	return
+}
+
+func statusPayload(network *Network, graph string) map[string]interface{} {
+	running := network.Running()
+	return map[string]interface{}{
+		"graph":   graph,
+		"running": running,
+		"started": running,
+		"time":    time.Now().Format(time.RFC3339),
+	}
+}
+
+func (rs *RuntimeServer) packageSent(network *Network, graph string, edge Edge, informationPackage *InformationPackage) {
+	rs.mux.Lock()
+	watched := rs.networks[graph] == network && rs.watched[graph][edge]
+	rs.mux.Unlock()
+	if !watched {
+		return
+	}
+
+	rs.broadcast("network", "data", map[string]interface{}{
+		"id":    edge.ID(),
+		"graph": graph,
+		"src":   runtimeEndpoint{Node: edge.From, Port: outPortName},
+		"tgt":   runtimeEndpoint{Node: edge.To, Port: inPortName},
+		"data":  packageData(informationPackage),
+	})
+}
+
+// packageData returns a JSON friendly view of the package
+func packageData(informationPackage *InformationPackage) interface{} {
+	data := map[string]interface{}{
+		"id":    informationPackage.ID,
+		"items": statusItems(informationPackage.Status),
+	}
+	if _, err := json.Marshal(data); err != nil {
+		data["items"] = fmt.Sprint(data["items"])
+	}
+	return data
+}
+
+func newDataPackage(id string, data interface{}) *InformationPackage {
+	informationPackage := NewInformationPackage(id, nil)
+	informationPackage.Status.Add(func() string { return "data" }, data)
+	return informationPackage
+}
+
+func (rs *RuntimeServer) sendError(ws *wsConn, protocol string, err error) {
+	if protocol == "" {
+		protocol = "runtime"
+	}
+	rs.send(ws, protocol, "error", map[string]string{"message": err.Error()})
+}
+
+func (rs *RuntimeServer) send(ws *wsConn, protocol string, command string, payload interface{}) (err error) {
+	data, err := marshalRuntimeMessage(protocol, command, payload)
+	if err != nil {
+		return
+	}
+	return ws.WriteMessage(data)
+}
+
+func (rs *RuntimeServer) broadcast(protocol string, command string, payload interface{}) {
+	data, err := marshalRuntimeMessage(protocol, command, payload)
+	if err != nil {
+		rs.errorHandler.Handle(err)
+		return
+	}
+
+	rs.mux.Lock()
+	clients := make([]*wsConn, 0, len(rs.clients))
+	for ws := range rs.clients {
+		clients = append(clients, ws)
+	}
+	rs.mux.Unlock()
+
+	for _, ws := range clients {
+		if err := ws.WriteMessage(data); err != nil {
+			rs.logger.Warn("writing to runtime client", Err(err))
+		}
+	}
+}
+
+func marshalRuntimeMessage(protocol string, command string, payload interface{}) ([]byte, error) {
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(runtimeMessage{
+		Protocol: protocol,
+		Command:  command,
+		Payload:  raw,
+	})
+}
@@ -0,0 +1,56 @@
+package fbp
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// shuffler perturbs the scheduling of the components and the connections
+// with random delays, and the fan out choices of the networks, to expose
+// the assumptions on the order of the packages
+type shuffler struct {
+	mux      sync.Mutex
+	rand     *rand.Rand
+	maxDelay time.Duration
+}
+
+// WithShuffle makes the components and the connections wait a random time
+// up to maxDelay before handling each package, and the networks fan out at
+// random. All the components, connections and networks given the option
+// share its random source, so they don't all make the same choices. The same
+// seed gives the same random choices, although the goroutines can still be
+// scheduled differently. It's meant for testing
+func WithShuffle(seed int64, maxDelay time.Duration) Option {
+	s := &shuffler{
+		rand:     rand.New(rand.NewSource(seed)),
+		maxDelay: maxDelay,
+	}
+	return func(o *options) {
+		o.shuffle = s
+	}
+}
+
+func (s *shuffler) delay() {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	d := time.Duration(s.rand.Int63n(int64(s.maxDelay) + 1))
+	s.mux.Unlock()
+
+	if d == 0 {
+		runtime.Gosched()
+		return
+	}
+	time.Sleep(d)
+}
+
+// strategy returns a random dispatch strategy seeded by the shuffler
+func (s *shuffler) strategy() DispatchStrategy {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return NewRandom(s.rand.Int63())
+}
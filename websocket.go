@@ -0,0 +1,236 @@
+package fbp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A minimal RFC 6455 server side implementation, just enough to speak the
+// FBP runtime protocol without pulling a websocket dependency
+
+const (
+	wsGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsVersion       = "13"
+	wsMaxMessageSz  = 16 << 20
+	wsOpContinue    = 0x0
+	wsOpText        = 0x1
+	wsOpBinary      = 0x2
+	wsOpClose       = 0x8
+	wsOpPing        = 0x9
+	wsOpPong        = 0xA
+	wsFinalFragment = 0x80
+)
+
+var (
+	ErrWebSocketMessageTooLarge = errors.New("websocket message too large")
+	ErrWebSocketUnmaskedFrame   = errors.New("unmasked websocket client frame")
+)
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	wmux sync.Mutex
+}
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, protocols ...string) (ws *wsConn, err error) {
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errors.New("websocket upgrade required")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != wsVersion {
+		w.Header().Set("Sec-WebSocket-Version", wsVersion)
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("response writer can't be hijacked")
+	}
+
+	var protocol string
+	for _, p := range protocols {
+		if headerContains(r.Header, "Sec-WebSocket-Protocol", p) {
+			protocol = p
+			break
+		}
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h.Sum(nil)) + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	if _, err = rw.WriteString(response + "\r\n"); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+	ws = &wsConn{
+		conn: conn,
+		rw:   rw,
+	}
+	return
+}
+
+// sameOrigin reports whether the request comes from a page of the same host,
+// or from a client that is not a browser and sends no Origin
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func headerContains(header http.Header, name string, value string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message, answering the pings
+// and joining the fragments it finds on the way
+func (ws *wsConn) ReadMessage() (message []byte, err error) {
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err = ws.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+		case wsOpPong:
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinue:
+			message = append(message, payload...)
+			if len(message) > wsMaxMessageSz {
+				return nil, ErrWebSocketMessageTooLarge
+			}
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, errors.New("unknown websocket opcode")
+		}
+	}
+}
+
+func (ws *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(ws.rw, header); err != nil {
+		return
+	}
+	fin = header[0]&wsFinalFragment != 0
+	opcode = header[0] & 0x0f
+	// The clients must mask all their frames
+	if header[1]&0x80 == 0 {
+		err = ErrWebSocketUnmaskedFrame
+		return
+	}
+
+	sz := uint64(header[1] & 0x7f)
+	switch sz {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(ws.rw, ext); err != nil {
+			return
+		}
+		sz = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(ws.rw, ext); err != nil {
+			return
+		}
+		sz = binary.BigEndian.Uint64(ext)
+	}
+	if sz > wsMaxMessageSz {
+		err = ErrWebSocketMessageTooLarge
+		return
+	}
+
+	mask := make([]byte, 4)
+	if _, err = io.ReadFull(ws.rw, mask); err != nil {
+		return
+	}
+	payload = make([]byte, sz)
+	if _, err = io.ReadFull(ws.rw, payload); err != nil {
+		return
+	}
+	for k := range mask {
+		for i := k; i < len(payload); i += 4 {
+			payload[i] ^= mask[k]
+		}
+	}
+	return
+}
+
+func (ws *wsConn) WriteMessage(message []byte) error {
+	return ws.writeFrame(wsOpText, message)
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) (err error) {
+	ws.wmux.Lock()
+	defer ws.wmux.Unlock()
+
+	header := []byte{wsFinalFragment | opcode}
+	switch sz := len(payload); {
+	case sz < 126:
+		header = append(header, byte(sz))
+	case sz <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(sz))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(sz))
+	}
+	if _, err = ws.rw.Write(header); err != nil {
+		return
+	}
+	if _, err = ws.rw.Write(payload); err != nil {
+		return
+	}
+	return ws.rw.Flush()
+}
+
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}
@@ -0,0 +1,425 @@
+package fbp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	TumblingWindow WindowKind = iota
+	SlidingWindow
+	SessionWindow
+)
+
+const (
+	ProcessingTime TimeDomain = iota
+	EventTime
+)
+
+const (
+	minWindowTick = time.Millisecond
+	maxWindowTick = time.Second
+)
+
+var ErrLatePackage = errors.New("package arrived after its windows were emitted")
+
+type (
+	WindowKind int
+	TimeDomain int
+
+	WindowBounds struct {
+		Key   string
+		Start time.Time
+		End   time.Time
+	}
+
+	// Aggregator builds the package emitted for a window from the packages
+	// it contains. Returning nil emits nothing
+	Aggregator func(bounds WindowBounds, packages []*InformationPackage) *InformationPackage
+
+	WindowSpec struct {
+		Kind   WindowKind
+		Domain TimeDomain
+
+		// Size is the length of tumbling and sliding windows, and Slide how
+		// often a new sliding window starts
+		Size  time.Duration
+		Slide time.Duration
+
+		// Gap is the inactivity that closes a session window
+		Gap time.Duration
+
+		// EventTime extracts the event time of the packages, and it's required
+		// for the EventTime domain. The watermark follows the latest event time
+		// seen minus the AllowedLateness, and the packages arriving behind the
+		// watermark are handled as errors
+		EventTime       func(ip *InformationPackage) time.Time
+		AllowedLateness time.Duration
+
+		// Key splits the packages in independent windows. Optional
+		Key func(ip *InformationPackage) string
+
+		// Tick is how often the processing time windows are checked. By
+		// default it's a tenth of the window duration
+		Tick time.Duration
+	}
+
+	openWindow struct {
+		bounds   WindowBounds
+		packages []*InformationPackage
+	}
+
+	windowSnapshot struct {
+		Watermark time.Time
+		Windows   []openWindowSnapshot
+	}
+
+	openWindowSnapshot struct {
+		Bounds   WindowBounds
+		Packages [][]byte
+	}
+
+	// Window groups the packages received in its port in time windows, and
+	// sends an aggregated package by the out port when each window closes
+	Window struct {
+		id           string
+		port         *Port
+		spec         WindowSpec
+		aggregate    Aggregator
+		errorHandler *ErrorHandler
+		logger       Logger
+		ctx          context.Context
+		clock        Clock
+		checkpointer *Checkpointer
+		codec        Codec
+
+		windows   map[string][]*openWindow
+		watermark time.Time
+	}
+)
+
+func NewWindow(ctx context.Context, id string, port *Port, spec WindowSpec, aggregate Aggregator, errorHandler *ErrorHandler, logger Logger) (w *Window, err error) {
+	if err = spec.validate(); err != nil {
+		return
+	}
+	if aggregate == nil {
+		return nil, errors.New("window requires an aggregator")
+	}
+	w = &Window{
+		ctx:          ctx,
+		id:           id,
+		port:         port,
+		spec:         spec,
+		aggregate:    aggregate,
+		errorHandler: errorHandler,
+		logger:       logger.With(String("component_id", id), String("port_id", port.ID)),
+		clock:        RealClock{},
+		windows:      make(map[string][]*openWindow),
+	}
+	return
+}
+
+func (spec *WindowSpec) validate() error {
+	switch spec.Kind {
+	case TumblingWindow:
+		if spec.Size <= 0 {
+			return errors.New("tumbling window requires a size")
+		}
+	case SlidingWindow:
+		if spec.Size <= 0 || spec.Slide <= 0 {
+			return errors.New("sliding window requires a size and a slide")
+		}
+	case SessionWindow:
+		if spec.Gap <= 0 {
+			return errors.New("session window requires a gap")
+		}
+	default:
+		return fmt.Errorf("unknown window kind %d", spec.Kind)
+	}
+	if spec.Domain == EventTime && spec.EventTime == nil {
+		return errors.New("event time windows require an event time extractor")
+	}
+	if spec.Tick == 0 {
+		spec.Tick = spec.duration() / 10
+		if spec.Tick < minWindowTick {
+			spec.Tick = minWindowTick
+		}
+		if spec.Tick > maxWindowTick {
+			spec.Tick = maxWindowTick
+		}
+	}
+	return nil
+}
+
+func (spec WindowSpec) duration() time.Duration {
+	switch spec.Kind {
+	case SlidingWindow:
+		if spec.Slide < spec.Size {
+			return spec.Slide
+		}
+		return spec.Size
+	case SessionWindow:
+		return spec.Gap
+	}
+	return spec.Size
+}
+
+// SetClock sets the clock of the processing time windows. It must be called
+// before streaming
+func (w *Window) SetClock(clock Clock) {
+	w.clock = clock
+}
+
+// SetCheckpointer makes the window save its open windows when it receives a
+// checkpoint barrier, encoding their packages with the codec. It must be
+// called before streaming
+func (w *Window) SetCheckpointer(checkpointer *Checkpointer, codec Codec) {
+	w.checkpointer = checkpointer
+	w.codec = codec
+	checkpointer.Register(w.id, w)
+}
+
+// Snapshot returns the watermark and the open windows with their packages, so
+// the windows are emitted after a restore as if the barrier never happened
+func (w *Window) Snapshot() (state []byte, err error) {
+	snapshot := windowSnapshot{Watermark: w.watermark}
+	for _, windows := range w.windows {
+		for _, window := range windows {
+			packages, err := encodePackages(w.codec, window.packages)
+			if err != nil {
+				return nil, err
+			}
+			snapshot.Windows = append(snapshot.Windows, openWindowSnapshot{Bounds: window.bounds, Packages: packages})
+		}
+	}
+	return json.Marshal(snapshot)
+}
+
+func (w *Window) Restore(state []byte) (err error) {
+	var snapshot windowSnapshot
+	if err = json.Unmarshal(state, &snapshot); err != nil {
+		return
+	}
+	windows := make(map[string][]*openWindow)
+	for _, window := range snapshot.Windows {
+		packages, err := decodePackages(w.codec, window.Packages)
+		if err != nil {
+			return err
+		}
+		key := window.Bounds.Key
+		windows[key] = append(windows[key], &openWindow{bounds: window.Bounds, packages: packages})
+	}
+	w.watermark, w.windows = snapshot.Watermark, windows
+	return
+}
+
+func (w *Window) checkpoint(barrier *InformationPackage) {
+	if w.checkpointer == nil {
+		return
+	}
+	state, err := w.Snapshot()
+	if err == nil {
+		err = w.checkpointer.save(barrier.Barrier, w.id, state)
+	}
+	if err != nil {
+		w.errorHandler.Handle(fmt.Errorf("window %s: %w", w.id, err))
+	}
+}
+
+func (w *Window) Stream() {
+	go func() {
+		w.logger.Info("window starting")
+
+		var tick <-chan time.Time
+		if w.spec.Domain == ProcessingTime {
+			ticker := w.clock.NewTicker(w.spec.Tick)
+			defer ticker.Stop()
+			tick = ticker.C()
+		}
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-tick:
+				// The clock is read again, as the tick may have waited
+				if !w.advance(w.clock.Now()) {
+					return
+				}
+			case informationPackage, ok := <-w.port.In:
+				if !ok {
+					w.logger.Warn("in port closed")
+					w.flush()
+					return
+				}
+				if informationPackage.IsBarrier() {
+					w.checkpoint(informationPackage)
+					if !w.send(informationPackage) {
+						return
+					}
+					continue
+				}
+				if !w.add(informationPackage) {
+					return
+				}
+			}
+		}
+	}()
+
+	return
+}
+
+func (w *Window) add(informationPackage *InformationPackage) bool {
+	var key string
+	if w.spec.Key != nil {
+		key = w.spec.Key(informationPackage)
+	}
+
+	t := w.clock.Now()
+	if w.spec.Domain == EventTime {
+		t = w.spec.EventTime(informationPackage)
+	}
+
+	if !w.assign(key, t, informationPackage) {
+		w.errorHandler.Handle(fmt.Errorf("window %s, package %s: %w", w.id, informationPackage.ID, ErrLatePackage))
+		return true
+	}
+
+	if w.spec.Domain == EventTime {
+		return w.advance(t.Add(-w.spec.AllowedLateness))
+	}
+	return true
+}
+
+// assign adds the package to all the windows it belongs to that are still
+// open. It returns false when all of them have already been emitted
+func (w *Window) assign(key string, t time.Time, informationPackage *InformationPackage) (assigned bool) {
+	switch w.spec.Kind {
+	case TumblingWindow:
+		start := t.Truncate(w.spec.Size)
+		return w.assignTo(key, start, start.Add(w.spec.Size), informationPackage)
+	case SlidingWindow:
+		for start := t.Truncate(w.spec.Slide); start.After(t.Add(-w.spec.Size)); start = start.Add(-w.spec.Slide) {
+			if w.assignTo(key, start, start.Add(w.spec.Size), informationPackage) {
+				assigned = true
+			}
+		}
+		return
+	case SessionWindow:
+		return w.assignSession(key, t, informationPackage)
+	}
+	return
+}
+
+func (w *Window) assignTo(key string, start time.Time, end time.Time, informationPackage *InformationPackage) bool {
+	if !end.After(w.watermark) {
+		return false
+	}
+	for _, window := range w.windows[key] {
+		if window.bounds.Start.Equal(start) {
+			window.packages = append(window.packages, informationPackage)
+			return true
+		}
+	}
+	w.windows[key] = append(w.windows[key], &openWindow{
+		bounds:   WindowBounds{Key: key, Start: start, End: end},
+		packages: []*InformationPackage{informationPackage},
+	})
+	return true
+}
+
+// assignSession opens a session for the package and merges it with all the
+// sessions of the key that overlap with it
+func (w *Window) assignSession(key string, t time.Time, informationPackage *InformationPackage) bool {
+	session := &openWindow{
+		bounds:   WindowBounds{Key: key, Start: t, End: t.Add(w.spec.Gap)},
+		packages: []*InformationPackage{informationPackage},
+	}
+	if !session.bounds.End.After(w.watermark) {
+		return false
+	}
+
+	var others []*openWindow
+	for _, window := range w.windows[key] {
+		if window.bounds.Start.Before(session.bounds.End) && session.bounds.Start.Before(window.bounds.End) {
+			if window.bounds.Start.Before(session.bounds.Start) {
+				session.bounds.Start = window.bounds.Start
+			}
+			if window.bounds.End.After(session.bounds.End) {
+				session.bounds.End = window.bounds.End
+			}
+			session.packages = append(window.packages, session.packages...)
+			continue
+		}
+		others = append(others, window)
+	}
+	w.windows[key] = append(others, session)
+	return true
+}
+
+// advance moves the watermark forward, emitting the windows that end before it
+func (w *Window) advance(watermark time.Time) bool {
+	if !watermark.After(w.watermark) {
+		return true
+	}
+	w.watermark = watermark
+
+	var closed []*openWindow
+	for key, windows := range w.windows {
+		var open []*openWindow
+		for _, window := range windows {
+			if window.bounds.End.After(watermark) {
+				open = append(open, window)
+			} else {
+				closed = append(closed, window)
+			}
+		}
+		if len(open) == 0 {
+			delete(w.windows, key)
+		} else {
+			w.windows[key] = open
+		}
+	}
+	return w.emit(closed)
+}
+
+func (w *Window) flush() {
+	var closed []*openWindow
+	for _, windows := range w.windows {
+		closed = append(closed, windows...)
+	}
+	w.windows = make(map[string][]*openWindow)
+	w.emit(closed)
+}
+
+func (w *Window) emit(windows []*openWindow) bool {
+	sort.Slice(windows, func(i, j int) bool {
+		if !windows[i].bounds.End.Equal(windows[j].bounds.End) {
+			return windows[i].bounds.End.Before(windows[j].bounds.End)
+		}
+		return windows[i].bounds.Key < windows[j].bounds.Key
+	})
+	for _, window := range windows {
+		out := w.aggregate(window.bounds, window.packages)
+		if out == nil {
+			continue
+		}
+		if !w.send(out) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Window) send(informationPackage *InformationPackage) bool {
+	select {
+	case <-w.ctx.Done():
+		return false
+	case w.port.Out <- informationPackage:
+		return true
+	}
+}
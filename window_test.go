@@ -0,0 +1,250 @@
+package fbp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theskyinflames/fbp"
+)
+
+var windowEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type (
+	// windowEvent happens At a time since the windowEpoch
+	windowEvent struct {
+		At time.Duration
+	}
+
+	windowResult struct {
+		Start time.Duration
+		End   time.Duration
+		Count int
+	}
+)
+
+func (we windowEvent) Key() func() string {
+	return func() string {
+		return "window_event"
+	}
+}
+
+func (wr windowResult) Key() func() string {
+	return func() string {
+		return "window_result"
+	}
+}
+
+func eventTime(ip *fbp.InformationPackage) time.Time {
+	item, _ := ip.Status.Peek(windowEvent{}.Key())
+	return windowEpoch.Add(item.(windowEvent).At)
+}
+
+func countWindow(bounds fbp.WindowBounds, packages []*fbp.InformationPackage) *fbp.InformationPackage {
+	return fbp.NewInformationPackage(
+		fmt.Sprintf("window_%s", bounds.Start.Sub(windowEpoch)),
+		windowResult{Start: bounds.Start.Sub(windowEpoch), End: bounds.End.Sub(windowEpoch), Count: len(packages)},
+	)
+}
+
+func newTestWindow(t *testing.T, ctx context.Context, spec fbp.WindowSpec, errorHandler *fbp.ErrorHandler) (*fbp.Window, *fbp.Port) {
+	t.Helper()
+
+	if errorHandler == nil {
+		errorHandler = fbp.NewErrorHandler(fbp.NewNopLogger())
+	}
+	port := fbp.NewPort("window", make(chan *fbp.InformationPackage), make(chan *fbp.InformationPackage, 16))
+	w, err := fbp.NewWindow(ctx, "window", port, spec, countWindow, errorHandler, fbp.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, port
+}
+
+func sendEvents(port *fbp.Port, events ...time.Duration) {
+	for _, at := range events {
+		port.In <- fbp.NewInformationPackage(fmt.Sprintf("event_%s", at), windowEvent{At: at})
+	}
+}
+
+func receiveWindows(t *testing.T, port *fbp.Port, n int) (got []windowResult) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for len(got) < n {
+		select {
+		case ip := <-port.Out:
+			item, err := ip.Status.Peek(windowResult{}.Key())
+			if err != nil {
+				t.Fatalf("package %s: %s", ip.ID, err)
+			}
+			got = append(got, item.(windowResult))
+		case <-timeout:
+			t.Fatalf("received windows %v, want %d", got, n)
+		}
+	}
+	return
+}
+
+func assertWindows(t *testing.T, got []windowResult, want []windowResult) {
+	t.Helper()
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got windows %v, want %v", got, want)
+	}
+}
+
+func TestWindowEventTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   fbp.WindowSpec
+		events []time.Duration
+		want   []windowResult
+	}{
+		{
+			name:   "tumbling",
+			spec:   fbp.WindowSpec{Kind: fbp.TumblingWindow, Size: time.Hour},
+			events: []time.Duration{0, 10 * time.Minute, 70 * time.Minute},
+			want: []windowResult{
+				{Start: 0, End: time.Hour, Count: 2},
+				{Start: time.Hour, End: 2 * time.Hour, Count: 1},
+			},
+		},
+		{
+			name:   "sliding",
+			spec:   fbp.WindowSpec{Kind: fbp.SlidingWindow, Size: time.Hour, Slide: 30 * time.Minute},
+			events: []time.Duration{0, 40 * time.Minute},
+			want: []windowResult{
+				{Start: -30 * time.Minute, End: 30 * time.Minute, Count: 1},
+				{Start: 0, End: time.Hour, Count: 2},
+				{Start: 30 * time.Minute, End: 90 * time.Minute, Count: 1},
+			},
+		},
+		{
+			name:   "session",
+			spec:   fbp.WindowSpec{Kind: fbp.SessionWindow, Gap: 10 * time.Minute},
+			events: []time.Duration{0, 5 * time.Minute, 30 * time.Minute},
+			want: []windowResult{
+				{Start: 0, End: 15 * time.Minute, Count: 2},
+				{Start: 30 * time.Minute, End: 40 * time.Minute, Count: 1},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tc.spec.Domain = fbp.EventTime
+			tc.spec.EventTime = eventTime
+			w, port := newTestWindow(t, ctx, tc.spec, nil)
+			w.Stream()
+
+			// Closing the in port emits the windows still open
+			sendEvents(port, tc.events...)
+			close(port.In)
+			assertWindows(t, receiveWindows(t, port, len(tc.want)), tc.want)
+		})
+	}
+}
+
+func TestWindowLateData(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mux  sync.Mutex
+		errs []error
+	)
+	errorHandler := fbp.NewErrorHandlerFunc(fbp.NewNopLogger(), func(err error) {
+		mux.Lock()
+		defer mux.Unlock()
+		errs = append(errs, err)
+	})
+	w, port := newTestWindow(t, ctx, fbp.WindowSpec{
+		Kind:            fbp.TumblingWindow,
+		Domain:          fbp.EventTime,
+		Size:            time.Hour,
+		EventTime:       eventTime,
+		AllowedLateness: 15 * time.Minute,
+	}, errorHandler)
+	w.SetClock(fbp.NewFakeClock(windowEpoch))
+	w.Stream()
+
+	// The watermark reaches 65m, so the first window is emitted and the
+	// package at 50m is late, while the one at 70m is still on time
+	sendEvents(port, 0, 80*time.Minute)
+	assertWindows(t, receiveWindows(t, port, 1), []windowResult{{Start: 0, End: time.Hour, Count: 1}})
+	sendEvents(port, 50*time.Minute, 70*time.Minute)
+	close(port.In)
+	assertWindows(t, receiveWindows(t, port, 1), []windowResult{{Start: time.Hour, End: 2 * time.Hour, Count: 2}})
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(errs) != 1 || !errors.Is(errs[0], fbp.ErrLatePackage) {
+		t.Errorf("got errors %v, want a late package one", errs)
+	}
+}
+
+func TestWindowProcessingTime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := fbp.NewFakeClock(windowEpoch)
+	w, port := newTestWindow(t, ctx, fbp.WindowSpec{Kind: fbp.TumblingWindow, Size: time.Hour}, nil)
+	w.SetClock(clock)
+	w.Stream()
+	clock.BlockUntil(1)
+
+	sendEvents(port, 0, 0)
+	clock.Advance(30 * time.Minute)
+	sendEvents(port, 0)
+	select {
+	case ip := <-port.Out:
+		t.Fatalf("window %s emitted before its end", ip.ID)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(30 * time.Minute)
+	assertWindows(t, receiveWindows(t, port, 1), []windowResult{{Start: 0, End: time.Hour, Count: 3}})
+}
+
+func TestWindowCheckpoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	codec := fbp.NewJSONCodec()
+	codec.Register(windowEvent{})
+	spec := fbp.WindowSpec{Kind: fbp.TumblingWindow, Domain: fbp.EventTime, Size: time.Hour, EventTime: eventTime}
+
+	checkpointer, err := fbp.NewCheckpointer(dir, fbp.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, port := newTestWindow(t, ctx, spec, nil)
+	w.SetCheckpointer(checkpointer, codec)
+	w.Stream()
+	sendEvents(port, 0, 10*time.Minute)
+	if _, err = checkpointer.Trigger(ctx, port); err != nil {
+		t.Fatal(err)
+	}
+
+	// The open window is restored with its packages, so it's emitted whole
+	checkpointer, err = fbp.NewCheckpointer(dir, fbp.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, restoredPort := newTestWindow(t, ctx, spec, nil)
+	restored.SetCheckpointer(checkpointer, codec)
+	if _, err = checkpointer.RestoreLatest(); err != nil {
+		t.Fatal(err)
+	}
+	restored.Stream()
+	sendEvents(restoredPort, 20*time.Minute)
+	close(restoredPort.In)
+	assertWindows(t, receiveWindows(t, restoredPort, 1), []windowResult{{Start: 0, End: time.Hour, Count: 3}})
+}